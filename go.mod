@@ -4,6 +4,8 @@ go 1.16
 
 require (
 	github.com/alokmenghrajani/gpgeez v0.0.0-20161206084504-1a06f1c582f9
+	github.com/hashicorp/go-multierror v1.1.0
+	github.com/hashicorp/go-retryablehttp v0.6.7
 	github.com/hashicorp/terraform-plugin-sdk/v2 v2.4.4
 	github.com/hashicorp/vault v1.6.3
 	github.com/hashicorp/vault/api v1.0.5-0.20201001211907-38d91b749c77
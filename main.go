@@ -13,4 +13,8 @@ func main() {
 			return secretmgr.Provider()
 		},
 	})
+
+	// Serve returns once Terraform shuts the plugin down, at which point the
+	// child tokens are no longer needed.
+	secretmgr.RevokeChildTokens()
 }
@@ -0,0 +1,151 @@
+package secretmgr
+
+import (
+	"fmt"
+	"log"
+	PATH "path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// archiveTimeFormat is the format of the timestamp suffix of archives.
+const archiveTimeFormat = "20060102T150405Z"
+
+// copySecret copies the secret at the logical path src to dst. On KV v2 every
+// version that can still be read is copied, oldest first, so dst keeps the
+// history of src. It reports whether there was anything to copy.
+func copySecret(src, dst string, meta *ProviderMeta) (bool, error) {
+	_, v2, err := isKVv2(src, meta)
+	if err != nil {
+		return false, fmt.Errorf("error determining if it's a v2 path: %s", err)
+	}
+
+	versions := []int{latestSecretVersion}
+	if v2 {
+		metadata, err := readSecretMetadata(src, meta)
+		if err != nil {
+			return false, err
+		}
+
+		versions = nil
+		allVersions, _ := metadata["versions"].(map[string]interface{})
+		for k, v := range allVersions {
+			// Deleted versions cannot be read and are skipped below.
+			version, _ := v.(map[string]interface{})
+			if version["destroyed"] == true {
+				continue
+			}
+			n, err := strconv.Atoi(k)
+			if err != nil {
+				return false, fmt.Errorf("error parsing version %q of %q: %s", k, src, err)
+			}
+			versions = append(versions, n)
+		}
+		sort.Ints(versions)
+	}
+
+	copied := false
+	for _, version := range versions {
+		secret, err := versionedSecret(version, src, meta)
+		if err != nil {
+			return copied, fmt.Errorf("error reading %q from Vault: %s", src, err)
+		}
+		if secret == nil {
+			continue
+		}
+
+		log.Printf("[DEBUG] Copying version %d of %s to %s", version, src, dst)
+		if _, err := writeVersionedSecret(dst, &secret.Data, nil, meta); err != nil {
+			return copied, fmt.Errorf("error writing %q to Vault: %s", dst, err)
+		}
+		copied = true
+	}
+
+	return copied, nil
+}
+
+// copySecretTree copies every secret under the logical path srcRoot to the
+// same relative path under dstRoot, and returns the number of secrets copied.
+func copySecretTree(srcRoot, dstRoot string, meta *ProviderMeta) (int, error) {
+	srcRoot = strings.TrimSuffix(srcRoot, "/")
+
+	var count int64
+	err := walkSecretTree(srcRoot, meta, func(p string) error {
+		dst := PATH.Join(dstRoot, strings.TrimPrefix(p, srcRoot))
+		copied, err := copySecret(p, dst, meta)
+		if copied {
+			atomic.AddInt64(&count, 1)
+		}
+		return err
+	})
+
+	return int(count), err
+}
+
+// latestArchive returns the newest archive of name, named
+// <name>-<timestamp>, directly under archiveRoot, or "" when there is none.
+func latestArchive(archiveRoot, name string, meta *ProviderMeta) (string, error) {
+	_, directories, err := listSecretTree(archiveRoot, 1, meta)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		latest     string
+		latestTime time.Time
+	)
+	for _, dir := range directories {
+		base := PATH.Base(dir)
+		if !strings.HasPrefix(base, name+"-") {
+			continue
+		}
+		t, err := time.Parse(archiveTimeFormat, strings.TrimPrefix(base, name+"-"))
+		if err != nil {
+			continue
+		}
+		if latest == "" || t.After(latestTime) {
+			latest, latestTime = dir, t
+		}
+	}
+
+	return latest, nil
+}
+
+// archiveHolds reports whether the latest version of every secret under the
+// logical path srcRoot that can still be read is the same in archive.
+func archiveHolds(srcRoot, archive string, meta *ProviderMeta) (bool, error) {
+	srcRoot = strings.TrimSuffix(srcRoot, "/")
+
+	var (
+		mu   sync.Mutex
+		held = true
+	)
+	err := walkSecretTree(srcRoot, meta, func(p string) error {
+		secret, err := versionedSecret(latestSecretVersion, p, meta)
+		if err != nil {
+			return fmt.Errorf("error reading %q from Vault: %s", p, err)
+		}
+		if secret == nil {
+			return nil
+		}
+
+		dst := PATH.Join(archive, strings.TrimPrefix(p, srcRoot))
+		archived, err := versionedSecret(latestSecretVersion, dst, meta)
+		if err != nil {
+			return fmt.Errorf("error reading %q from Vault: %s", dst, err)
+		}
+		if archived == nil || !reflect.DeepEqual(secret.Data, archived.Data) {
+			mu.Lock()
+			held = false
+			mu.Unlock()
+		}
+		return nil
+	})
+
+	return held, err
+}
@@ -0,0 +1,216 @@
+package secretmgr
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/vault/api"
+)
+
+// authLoginKeys are the mutually exclusive provider arguments that configure
+// how the provider logs in to Vault.
+var authLoginKeys = []string{
+	"username",
+	"auth_login",
+	"auth_login_approle",
+	"auth_login_kubernetes",
+	"auth_login_cert",
+	"auth_login_jwt",
+}
+
+// authLoginConflicts returns the login arguments that conflict with key.
+func authLoginConflicts(key string) []string {
+	var conflicts []string
+	for _, k := range authLoginKeys {
+		if k != key {
+			conflicts = append(conflicts, k)
+		}
+	}
+	return conflicts
+}
+
+// authLoginToken writes the login parameters to an auth method's login
+// endpoint and returns the client token from the response.
+func authLoginToken(client *api.Client, loginPath string, params map[string]interface{}) (string, error) {
+	log.Printf("[DEBUG] Logging in to Vault via %s", loginPath)
+
+	secret, err := client.Logical().Write(loginPath, params)
+	if err != nil {
+		return "", fmt.Errorf("error logging in to Vault via %q: %s", loginPath, err)
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return "", fmt.Errorf("login via %q did not return a client token", loginPath)
+	}
+
+	return secret.Auth.ClientToken, nil
+}
+
+// unwrapSecret unwraps a response-wrapped secret. A wrapping token can only be
+// unwrapped once, so a token that is no longer valid means that someone else
+// has already unwrapped the secret.
+func unwrapSecret(client *api.Client, wrappingToken string) (*api.Secret, error) {
+	// Unwrap with a copy of the client, authenticated with the wrapping
+	// token itself, so the provider client keeps its own token.
+	unwrapClient, err := client.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("error cloning Vault client: %s", err)
+	}
+	if namespace := clientNamespace(client); namespace != "" {
+		unwrapClient.SetNamespace(namespace)
+	}
+	unwrapClient.SetToken(wrappingToken)
+
+	// Look the token up first, so that an already used token is reported
+	// as such rather than as a generic permission error.
+	_, err = unwrapClient.Logical().Write("sys/wrapping/lookup", map[string]interface{}{
+		"token": wrappingToken,
+	})
+	if respErr, ok := err.(*api.ResponseError); ok && respErr.StatusCode == 400 {
+		return nil, fmt.Errorf("wrapping token is not valid: it has expired or was already unwrapped, "+
+			"which may mean it was intercepted: %s", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error looking up wrapping token: %s", err)
+	}
+
+	secret, err := unwrapClient.Logical().Write("sys/wrapping/unwrap", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping wrapping token, it may have been intercepted: %s", err)
+	}
+	if secret == nil {
+		return nil, errors.New("unwrapping returned no secret")
+	}
+
+	return secret, nil
+}
+
+// providerLoginToken logs in with the auth method configured on the provider
+// and returns the resulting client token. It returns an empty token when no
+// login is configured, in which case the provider token is used as is.
+func providerLoginToken(client *api.Client, d *schema.ResourceData) (string, error) {
+	if v, ok := d.GetOk("auth_login"); ok {
+		login := v.([]interface{})[0].(map[string]interface{})
+
+		params := make(map[string]interface{})
+		for k, v := range login["parameters"].(map[string]interface{}) {
+			params[k] = v
+		}
+
+		return authLoginToken(client, login["path"].(string), params)
+	}
+
+	// username and password are a shorthand for a userpass auth_login.
+	if username := d.Get("username").(string); username != "" {
+		params := map[string]interface{}{
+			"password": d.Get("password").(string),
+		}
+
+		return authLoginToken(client, fmt.Sprintf("auth/userpass/login/%s", username), params)
+	}
+
+	if v, ok := d.GetOk("auth_login_approle"); ok {
+		return approleLoginToken(client, v.([]interface{})[0].(map[string]interface{}))
+	}
+
+	if v, ok := d.GetOk("auth_login_kubernetes"); ok {
+		return kubernetesLoginToken(client, v.([]interface{})[0].(map[string]interface{}))
+	}
+
+	if v, ok := d.GetOk("auth_login_cert"); ok {
+		if _, ok := d.GetOk("client_auth"); !ok {
+			return "", errors.New("auth_login_cert requires a client_auth certificate")
+		}
+		return certLoginToken(client, v.([]interface{})[0].(map[string]interface{}))
+	}
+
+	if v, ok := d.GetOk("auth_login_jwt"); ok {
+		return jwtLoginToken(client, v.([]interface{})[0].(map[string]interface{}))
+	}
+
+	return "", nil
+}
+
+// approleLoginToken logs in with an auth_login_approle block.
+func approleLoginToken(client *api.Client, login map[string]interface{}) (string, error) {
+	params := map[string]interface{}{
+		"role_id": login["role_id"].(string),
+	}
+	if secretID := login["secret_id"].(string); secretID != "" {
+		params["secret_id"] = secretID
+	}
+	if wrappedSecretID := login["wrapped_secret_id"].(string); wrappedSecretID != "" {
+		secret, err := unwrapSecret(client, wrappedSecretID)
+		if err != nil {
+			return "", err
+		}
+		secretID, ok := secret.Data["secret_id"].(string)
+		if !ok || secretID == "" {
+			return "", errors.New("wrapped_secret_id does not wrap an AppRole SecretID")
+		}
+		params["secret_id"] = secretID
+	}
+
+	return authLoginToken(client, fmt.Sprintf("auth/%s/login", login["mount"].(string)), params)
+}
+
+// kubernetesLoginToken logs in with an auth_login_kubernetes block, using the
+// service account JWT mounted into the pod.
+func kubernetesLoginToken(client *api.Client, login map[string]interface{}) (string, error) {
+	jwtFile := login["jwt_file"].(string)
+	jwt, err := ioutil.ReadFile(jwtFile)
+	if err != nil {
+		return "", fmt.Errorf("error reading service account token %q: %s", jwtFile, err)
+	}
+
+	params := map[string]interface{}{
+		"role": login["role"].(string),
+		"jwt":  strings.TrimSpace(string(jwt)),
+	}
+
+	return authLoginToken(client, fmt.Sprintf("auth/%s/login", login["mount"].(string)), params)
+}
+
+// certLoginToken logs in with an auth_login_cert block. The client
+// certificate itself is presented by the TLS transport.
+func certLoginToken(client *api.Client, login map[string]interface{}) (string, error) {
+	params := map[string]interface{}{}
+	if name := login["name"].(string); name != "" {
+		params["name"] = name
+	}
+
+	return authLoginToken(client, fmt.Sprintf("auth/%s/login", login["mount"].(string)), params)
+}
+
+// jwtLoginToken logs in with an auth_login_jwt block, reading the JWT from
+// jwt_file when set.
+func jwtLoginToken(client *api.Client, login map[string]interface{}) (string, error) {
+	jwt, _ := login["jwt"].(string)
+	jwtFile, _ := login["jwt_file"].(string)
+
+	switch {
+	case jwt != "" && jwtFile != "":
+		return "", errors.New("auth_login_jwt requires only one of jwt or jwt_file, " +
+			"jwt may be set by TERRAFORM_VAULT_AUTH_JWT")
+	case jwtFile != "":
+		contents, err := ioutil.ReadFile(jwtFile)
+		if err != nil {
+			return "", fmt.Errorf("error reading JWT %q: %s", jwtFile, err)
+		}
+		jwt = string(contents)
+	}
+	jwt = strings.TrimSpace(jwt)
+	if jwt == "" {
+		return "", errors.New("auth_login_jwt requires either jwt or jwt_file")
+	}
+
+	params := map[string]interface{}{
+		"role": login["role"].(string),
+		"jwt":  jwt,
+	}
+
+	return authLoginToken(client, fmt.Sprintf("auth/%s/login", login["mount"].(string)), params)
+}
@@ -0,0 +1,282 @@
+package secretmgr
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// testClient returns a client of a stand-in Vault server served by handler.
+func testClient(t *testing.T, handler http.Handler) *api.Client {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	config := api.DefaultConfig()
+	config.Address = srv.URL
+	config.MaxRetries = 0
+
+	client, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken("test-token")
+
+	// Ignore VAULT_NAMESPACE from the environment.
+	headers := client.Headers()
+	headers.Del(namespaceHeader)
+	client.SetHeaders(headers)
+
+	return client
+}
+
+func writeTestJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// testLoginHandler answers logins on loginPath with token when check accepts
+// the login parameters.
+func testLoginHandler(t *testing.T, loginPath, token string, check func(params map[string]interface{}) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/"+loginPath {
+			writeTestJSON(w, 404, map[string]interface{}{"errors": []string{"no handler for route"}})
+			return
+		}
+
+		var params map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			t.Errorf("error decoding login parameters: %s", err)
+		}
+		if !check(params) {
+			writeTestJSON(w, 400, map[string]interface{}{"errors": []string{"invalid credentials"}})
+			return
+		}
+
+		writeTestJSON(w, 200, map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": token},
+		})
+	}
+}
+
+func approleLogin(roleID, secretID, wrappedSecretID string) map[string]interface{} {
+	return map[string]interface{}{
+		"mount":             "approle",
+		"role_id":           roleID,
+		"secret_id":         secretID,
+		"wrapped_secret_id": wrappedSecretID,
+	}
+}
+
+func TestApproleLoginToken(t *testing.T) {
+	client := testClient(t, testLoginHandler(t, "auth/approle/login", "approle-token", func(params map[string]interface{}) bool {
+		return params["role_id"] == "role" && params["secret_id"] == "secret"
+	}))
+
+	token, err := approleLoginToken(client, approleLogin("role", "secret", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "approle-token" {
+		t.Fatalf("expected approle-token, got %q", token)
+	}
+
+	if _, err := approleLoginToken(client, approleLogin("role", "wrong", "")); err == nil {
+		t.Fatal("expected an error logging in with a wrong secret_id")
+	}
+}
+
+func TestApproleLoginTokenWithoutAuth(t *testing.T) {
+	client := testClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, 200, map[string]interface{}{"data": map[string]interface{}{}})
+	}))
+
+	_, err := approleLoginToken(client, approleLogin("role", "secret", ""))
+	if err == nil || !strings.Contains(err.Error(), "did not return a client token") {
+		t.Fatalf("expected a missing client token error, got %v", err)
+	}
+}
+
+func TestApproleLoginTokenWrappedSecretID(t *testing.T) {
+	unwrapped := false
+	login := testLoginHandler(t, "auth/approle/login", "approle-token", func(params map[string]interface{}) bool {
+		return params["role_id"] == "role" && params["secret_id"] == "unwrapped-secret"
+	})
+
+	client := testClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/sys/wrapping/lookup":
+			if unwrapped {
+				writeTestJSON(w, 400, map[string]interface{}{"errors": []string{"wrapping token is not valid or does not exist"}})
+				return
+			}
+			writeTestJSON(w, 200, map[string]interface{}{"data": map[string]interface{}{"creation_path": "auth/approle/role/role/secret-id"}})
+		case "/v1/sys/wrapping/unwrap":
+			if r.Header.Get("X-Vault-Token") != "wrapping-token" {
+				t.Errorf("expected unwrap with the wrapping token, got %q", r.Header.Get("X-Vault-Token"))
+			}
+			unwrapped = true
+			writeTestJSON(w, 200, map[string]interface{}{"data": map[string]interface{}{"secret_id": "unwrapped-secret"}})
+		default:
+			login(w, r)
+		}
+	}))
+
+	token, err := approleLoginToken(client, approleLogin("role", "", "wrapping-token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "approle-token" {
+		t.Fatalf("expected approle-token, got %q", token)
+	}
+	if client.Token() != "test-token" {
+		t.Fatalf("expected the client to keep its token, got %q", client.Token())
+	}
+
+	_, err = approleLoginToken(client, approleLogin("role", "", "wrapping-token"))
+	if err == nil || !strings.Contains(err.Error(), "intercepted") {
+		t.Fatalf("expected an already unwrapped error, got %v", err)
+	}
+}
+
+func TestKubernetesLoginToken(t *testing.T) {
+	client := testClient(t, testLoginHandler(t, "auth/kubernetes/login", "kubernetes-token", func(params map[string]interface{}) bool {
+		return params["role"] == "app" && params["jwt"] == "service-account-jwt"
+	}))
+
+	jwtFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(jwtFile, []byte("service-account-jwt\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := kubernetesLoginToken(client, map[string]interface{}{
+		"mount":    "kubernetes",
+		"role":     "app",
+		"jwt_file": jwtFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "kubernetes-token" {
+		t.Fatalf("expected kubernetes-token, got %q", token)
+	}
+}
+
+func TestKubernetesLoginTokenMissingFile(t *testing.T) {
+	client := testClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to %s", r.URL.Path)
+	}))
+
+	jwtFile := filepath.Join(t.TempDir(), "missing")
+	_, err := kubernetesLoginToken(client, map[string]interface{}{
+		"mount":    "kubernetes",
+		"role":     "app",
+		"jwt_file": jwtFile,
+	})
+	if err == nil || !strings.Contains(err.Error(), "error reading service account token") {
+		t.Fatalf("expected a missing service account token error, got %v", err)
+	}
+}
+
+// signTestJWT returns an HS256 JWT of claims signed with key.
+func signTestJWT(t *testing.T, key []byte, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, err := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signed))
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyTestJWT reports whether jwt is an HS256 JWT signed with key.
+func verifyTestJWT(jwt string, key []byte) bool {
+	i := strings.LastIndex(jwt, ".")
+	if i < 0 {
+		return false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(jwt[i+1:])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(jwt[:i]))
+	return hmac.Equal(signature, mac.Sum(nil))
+}
+
+func TestJwtLoginToken(t *testing.T) {
+	key := []byte("test-signing-key")
+	jwt := signTestJWT(t, key, map[string]interface{}{"sub": "ci", "aud": "vault"})
+
+	client := testClient(t, testLoginHandler(t, "auth/jwt/login", "jwt-token", func(params map[string]interface{}) bool {
+		jwt, _ := params["jwt"].(string)
+		return params["role"] == "ci" && verifyTestJWT(jwt, key)
+	}))
+
+	jwtFile := filepath.Join(t.TempDir(), "jwt")
+	if err := ioutil.WriteFile(jwtFile, []byte(jwt+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	forgedFile := filepath.Join(t.TempDir(), "forged")
+	forged := signTestJWT(t, []byte("other-key"), map[string]interface{}{"sub": "ci", "aud": "vault"})
+	if err := ioutil.WriteFile(forgedFile, []byte(forged), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name         string
+		jwt, jwtFile string
+		err          string
+	}{
+		{name: "jwt", jwt: jwt},
+		{name: "jwt_file", jwtFile: jwtFile},
+		{name: "forged", jwtFile: forgedFile, err: "invalid credentials"},
+		{name: "both", jwt: jwt, jwtFile: jwtFile, err: "only one of jwt or jwt_file"},
+		{name: "none", err: "requires either jwt or jwt_file"},
+		{name: "missing file", jwtFile: filepath.Join(t.TempDir(), "missing"), err: "error reading JWT"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			token, err := jwtLoginToken(client, map[string]interface{}{
+				"mount":    "jwt",
+				"role":     "ci",
+				"jwt":      c.jwt,
+				"jwt_file": c.jwtFile,
+			})
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("expected an error containing %q, got %v", c.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token != "jwt-token" {
+				t.Fatalf("expected jwt-token, got %q", token)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package secretmgr
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/hashicorp/vault/api"
+)
+
+// retryBackoff waits for the Retry-After period that Vault's rate limit
+// quotas send along with 429 responses, and otherwise backs off linearly with
+// jitter.
+func retryBackoff(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			return time.Duration(seconds)*time.Second + retryablehttp.LinearJitterBackoff(0, min, 0, resp)
+		}
+	}
+	return retryablehttp.LinearJitterBackoff(min, max, attemptNum, resp)
+}
+
+// limitedTransport bounds the number of requests in flight to Vault. A slot is
+// held until the response headers are received: the Vault client does not
+// close the body of every response, e.g. of redirects it follows, so waiting
+// for the body to be closed could hold a slot for good.
+type limitedTransport struct {
+	base http.RoundTripper
+	sem  chan struct{}
+}
+
+func newLimitedTransport(base http.RoundTripper, maxConcurrentRequests int) *limitedTransport {
+	return &limitedTransport{
+		base: base,
+		sem:  make(chan struct{}, maxConcurrentRequests),
+	}
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case t.sem <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	defer func() { <-t.sem }()
+
+	return t.base.RoundTrip(req)
+}
+
+// healthyAddresses probes sys/health on each address and returns the
+// addresses with the first active node first. When no node is active, the
+// first unsealed standby is used instead.
+func healthyAddresses(client *api.Client, addresses []string) ([]string, error) {
+	selected := -1
+	standby := -1
+	for i, addr := range addresses {
+		probe, err := client.Clone()
+		if err != nil {
+			return nil, fmt.Errorf("error cloning Vault client: %s", err)
+		}
+		probe.SetMaxRetries(0)
+		if err := probe.SetAddress(addr); err != nil {
+			return nil, fmt.Errorf("invalid Vault address %q: %s", addr, err)
+		}
+
+		health, err := probe.Sys().Health()
+		if err != nil {
+			log.Printf("[WARN] Vault at %s is unreachable: %s", addr, err)
+			continue
+		}
+		if !health.Initialized || health.Sealed {
+			log.Printf("[WARN] Vault at %s is not initialized or sealed", addr)
+			continue
+		}
+		if !health.Standby {
+			selected = i
+			break
+		}
+		if standby < 0 {
+			standby = i
+		}
+	}
+	if selected < 0 {
+		selected = standby
+	}
+	if selected < 0 {
+		return nil, fmt.Errorf("none of the Vault addresses is healthy: %v", addresses)
+	}
+
+	ordered := []string{addresses[selected]}
+	for i, addr := range addresses {
+		if i != selected {
+			ordered = append(ordered, addr)
+		}
+	}
+	return ordered, nil
+}
+
+// failoverTransport fails requests sent to one of its addresses over to the
+// next addresses when a connection error happens. Requests to the first
+// address, the one the client is configured with, are sent to the address that
+// last answered. Requests to the other addresses, such as redirects from a
+// standby to the active node, are first sent where they were addressed to.
+// Requests to other hosts are sent as is.
+type failoverTransport struct {
+	base      http.RoundTripper
+	addresses []*url.URL
+
+	mu      sync.Mutex
+	current int
+}
+
+func newFailoverTransport(base http.RoundTripper, addresses []string) (*failoverTransport, error) {
+	t := &failoverTransport{base: base}
+	for _, addr := range addresses {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Vault address %q: %s", addr, err)
+		}
+		t.addresses = append(t.addresses, u)
+	}
+	return t, nil
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target := -1
+	for i, addr := range t.addresses {
+		if req.URL.Host == addr.Host {
+			target = i
+			break
+		}
+	}
+	if target < 0 {
+		return t.base.RoundTrip(req)
+	}
+
+	// Buffer the body so that it can be sent again to the next address.
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	start := target
+	if target == 0 {
+		t.mu.Lock()
+		start = t.current
+		t.mu.Unlock()
+	}
+
+	var err error
+	for i := range t.addresses {
+		index := (start + i) % len(t.addresses)
+		addr := t.addresses[index]
+
+		attempt := req.Clone(req.Context())
+		attempt.URL.Scheme = addr.Scheme
+		attempt.URL.Host = addr.Host
+		attempt.Host = addr.Host
+		if body != nil {
+			attempt.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		var resp *http.Response
+		resp, err = t.base.RoundTrip(attempt)
+		if err == nil {
+			if i > 0 {
+				log.Printf("[INFO] Failed over to Vault at %s", addr)
+				t.mu.Lock()
+				t.current = index
+				t.mu.Unlock()
+			}
+			return resp, nil
+		}
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+
+		log.Printf("[WARN] Request to Vault at %s failed: %s", addr, err)
+	}
+
+	return nil, err
+}
@@ -0,0 +1,266 @@
+package secretmgr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	min, max := time.Second, 2*time.Second
+
+	tooManyRequests := func(retryAfter string) *http.Response {
+		resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+		if retryAfter != "" {
+			resp.Header.Set("Retry-After", retryAfter)
+		}
+		return resp
+	}
+
+	cases := []struct {
+		name       string
+		attemptNum int
+		resp       *http.Response
+		low, high  time.Duration
+	}{
+		{"no response", 0, nil, min, max},
+		{"third attempt", 2, nil, 3 * min, 3 * max},
+		{"server error", 1, &http.Response{StatusCode: 503}, 2 * min, 2 * max},
+		{"retry after", 2, tooManyRequests("5"), 5 * time.Second, 5*time.Second + min},
+		{"rate limited without retry after", 1, tooManyRequests(""), 2 * min, 2 * max},
+		{"invalid retry after", 0, tooManyRequests("Wed, 21 Oct 2015 07:28:00 GMT"), min, max},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				wait := retryBackoff(min, max, c.attemptNum, c.resp)
+				if wait < c.low || wait > c.high {
+					t.Fatalf("expected a backoff between %s and %s, got %s", c.low, c.high, wait)
+				}
+			}
+		})
+	}
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestLimitedTransport(t *testing.T) {
+	const limit = 2
+
+	var inFlight, maxInFlight int32
+	release := make(chan struct{})
+	transport := newLimitedTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			m := atomic.LoadInt32(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&inFlight, -1)
+		return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+	}), limit)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", "http://vault/v1/sys/health", nil)
+			// The body of the response is never closed, like the Vault
+			// client does with the redirects it follows.
+			if _, err := transport.RoundTrip(req); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if maxInFlight != limit {
+		t.Fatalf("expected at most %d requests in flight, got %d", limit, maxInFlight)
+	}
+	if len(transport.sem) != 0 {
+		t.Fatalf("expected every slot to be released, %d are still held", len(transport.sem))
+	}
+}
+
+func TestLimitedTransportCanceled(t *testing.T) {
+	transport := newLimitedTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		t.Error("unexpected request")
+		return nil, nil
+	}), 1)
+	transport.sem <- struct{}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://vault/v1/sys/health", nil)
+	if _, err := transport.RoundTrip(req); err != context.DeadlineExceeded {
+		t.Fatalf("expected the request to give up waiting for a slot, got %v", err)
+	}
+}
+
+// healthHandler answers sys/health like a Vault node in the given state, and
+// every other request with next.
+func healthHandler(initialized, sealed, standby bool, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/sys/health" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		writeTestJSON(w, 200, map[string]interface{}{
+			"initialized": initialized,
+			"sealed":      sealed,
+			"standby":     standby,
+		})
+	}
+}
+
+// testNode starts a stand-in Vault node in the given state, answering every
+// request but sys/health with its own name.
+func testNode(t *testing.T, name string, initialized, sealed, standby bool) string {
+	srv := httptest.NewServer(healthHandler(initialized, sealed, standby, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, 200, map[string]interface{}{"data": map[string]interface{}{"node": name}})
+	})))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// unreachableAddress is an address nothing listens on.
+const unreachableAddress = "http://127.0.0.1:1"
+
+func TestHealthyAddresses(t *testing.T) {
+	active := testNode(t, "active", true, false, false)
+	standby := testNode(t, "standby", true, false, true)
+	sealed := testNode(t, "sealed", true, true, false)
+	uninitialized := testNode(t, "uninitialized", false, false, false)
+
+	client := testClient(t, http.NotFoundHandler())
+
+	cases := []struct {
+		name      string
+		addresses []string
+		expected  []string
+		err       bool
+	}{
+		{"active first", []string{active, standby}, []string{active, standby}, false},
+		{"active after unreachable", []string{unreachableAddress, sealed, active}, []string{active, unreachableAddress, sealed}, false},
+		{"active after standby", []string{standby, active}, []string{active, standby}, false},
+		{"standby without active", []string{sealed, standby, uninitialized}, []string{standby, sealed, uninitialized}, false},
+		{"none healthy", []string{unreachableAddress, sealed, uninitialized}, nil, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			addresses, err := healthyAddresses(client, c.addresses)
+			if c.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", addresses)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(addresses, ",") != strings.Join(c.expected, ",") {
+				t.Fatalf("expected %v, got %v", c.expected, addresses)
+			}
+		})
+	}
+}
+
+// readNode returns the name of the node answering a read through transport.
+func readNode(t *testing.T, transport http.RoundTripper, method, addr string) string {
+	t.Helper()
+
+	req, err := http.NewRequest(method, addr+"/v1/secret/data/a", strings.NewReader(`{"data":{}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Data struct {
+			Node string `json:"node"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Data.Node
+}
+
+func TestFailoverTransport(t *testing.T) {
+	first := testNode(t, "first", true, false, false)
+	second := testNode(t, "second", true, false, true)
+	other := testNode(t, "other", true, false, false)
+
+	transport, err := newFailoverTransport(http.DefaultTransport, []string{first, unreachableAddress, second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if node := readNode(t, transport, "GET", first); node != "first" {
+		t.Fatalf("expected the first address to answer, got %q", node)
+	}
+	if node := readNode(t, transport, "GET", second); node != "second" {
+		t.Fatalf("expected a request to a configured address to be sent there, got %q", node)
+	}
+	if node := readNode(t, transport, "GET", other); node != "other" {
+		t.Fatalf("expected a request to another host to be sent as is, got %q", node)
+	}
+
+	// A request to an unreachable configured address fails over to the next
+	// one, with its body.
+	if node := readNode(t, transport, "PUT", unreachableAddress); node != "second" {
+		t.Fatalf("expected a request to an unreachable address to fail over, got %q", node)
+	}
+}
+
+func TestFailoverTransportKeepsAnsweringAddress(t *testing.T) {
+	second := testNode(t, "second", true, false, false)
+
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		attempts[req.URL.Host]++
+		mu.Unlock()
+		return http.DefaultTransport.RoundTrip(req)
+	})
+
+	transport, err := newFailoverTransport(base, []string{unreachableAddress, second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if node := readNode(t, transport, "PUT", unreachableAddress); node != "second" {
+			t.Fatalf("expected the request to fail over, got %q", node)
+		}
+	}
+
+	u, _ := url.Parse(unreachableAddress)
+	if attempts[u.Host] != 1 {
+		t.Fatalf("expected the unreachable address to be tried once, got %d attempts", attempts[u.Host])
+	}
+}
@@ -0,0 +1,79 @@
+package secretmgr
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+func dataSourceKvList() *schema.Resource {
+	return &schema.Resource{
+		Read: kvListDataSourceRead,
+
+		Schema: map[string]*schema.Schema{
+			"path": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Full path of the directory to list, including its mount.",
+			},
+			"recursive": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "List the sub-directories as well.",
+			},
+			"max_depth": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      0,
+				ValidateFunc: validation.IntAtLeast(0),
+				Description:  "Number of directory levels listed when recursive. 0 means unlimited.",
+			},
+			"namespace": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				Description: "Vault namespace of the directory. Defaults to the provider namespace.",
+			},
+			"secrets": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Sorted full paths of the secrets found.",
+			},
+			"directories": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Sorted full paths of the sub-directories found.",
+			},
+		},
+	}
+}
+
+func kvListDataSourceRead(d *schema.ResourceData, meta interface{}) error {
+	providerMeta, err := resourceCreateMeta(d, meta)
+	if err != nil {
+		return err
+	}
+
+	path := d.Get("path").(string)
+
+	maxDepth := 1
+	if d.Get("recursive").(bool) {
+		maxDepth = d.Get("max_depth").(int)
+	}
+
+	secrets, directories, err := listSecretTree(path, maxDepth, providerMeta)
+	if err != nil {
+		return fmt.Errorf("error listing %q: %s", path, err)
+	}
+
+	d.SetId(providerMeta.resourceID(path))
+	d.Set("namespace", providerMeta.namespace)
+	d.Set("secrets", secrets)
+	d.Set("directories", directories)
+
+	return nil
+}
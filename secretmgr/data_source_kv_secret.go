@@ -0,0 +1,142 @@
+package secretmgr
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/structure"
+)
+
+func dataSourceKvSecret() *schema.Resource {
+	return &schema.Resource{
+		Read: kvSecretDataSourceRead,
+
+		Schema: map[string]*schema.Schema{
+			"path": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Full path of the secret, including its mount.",
+			},
+			"version": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Computed:    true,
+				Description: "Version of the secret to read. Defaults to the latest version. KV v2 only.",
+			},
+			"allow_missing": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Return empty data instead of an error when the secret does not exist.",
+			},
+			"namespace": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				Description: "Vault namespace of the secret. Defaults to the provider namespace.",
+			},
+			"data": {
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Sensitive:   true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Data of the secret. Values that are not strings are JSON-encoded.",
+			},
+			"data_json": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Sensitive:   true,
+				Description: "JSON-encoded data of the secret.",
+			},
+			"created_time": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Time the version was created. KV v2 only.",
+			},
+			"deletion_time": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Time the version was deleted, if it was. KV v2 only.",
+			},
+			"destroyed": {
+				Type:        schema.TypeBool,
+				Computed:    true,
+				Description: "Whether the version was destroyed. KV v2 only.",
+			},
+			"custom_metadata": {
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Custom metadata of the secret. KV v2 only.",
+			},
+		},
+	}
+}
+
+func kvSecretDataSourceRead(d *schema.ResourceData, meta interface{}) error {
+	providerMeta, err := resourceCreateMeta(d, meta)
+	if err != nil {
+		return err
+	}
+
+	path := d.Get("path").(string)
+	version := d.Get("version").(int)
+	if version == 0 {
+		version = latestSecretVersion
+	}
+
+	log.Printf("[DEBUG] Reading %s from Vault", path)
+	secret, metadata, err := versionedSecretWithMetadata(version, path, providerMeta)
+	if err != nil {
+		return fmt.Errorf("error reading from Vault: %s", err)
+	}
+	// Deleted and destroyed versions have metadata but no data, only a
+	// secret without either is missing.
+	if secret == nil && metadata == nil && !d.Get("allow_missing").(bool) {
+		return fmt.Errorf("no secret found at %q", path)
+	}
+
+	data := map[string]interface{}{}
+	if secret != nil {
+		data = secret.Data
+	}
+
+	stringData := make(map[string]string, len(data))
+	for k, v := range data {
+		if s, ok := v.(string); ok {
+			stringData[k] = s
+			continue
+		}
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("error encoding %q of %q: %s", k, path, err)
+		}
+		stringData[k] = string(encoded)
+	}
+
+	dataJSON, err := structure.FlattenJsonToString(data)
+	if err != nil {
+		return fmt.Errorf("error encoding data of %q: %s", path, err)
+	}
+
+	d.SetId(providerMeta.resourceID(path))
+	d.Set("namespace", providerMeta.namespace)
+	d.Set("data", stringData)
+	d.Set("data_json", dataJSON)
+	d.Set("version", metadataInt(metadata, "version"))
+	d.Set("created_time", metadata["created_time"])
+	d.Set("deletion_time", metadata["deletion_time"])
+	d.Set("destroyed", metadata["destroyed"] == true)
+
+	customMetadata := map[string]string{}
+	if custom, ok := metadata["custom_metadata"].(map[string]interface{}); ok {
+		for k, v := range custom {
+			customMetadata[k] = fmt.Sprint(v)
+		}
+	}
+	d.Set("custom_metadata", customMetadata)
+
+	return nil
+}
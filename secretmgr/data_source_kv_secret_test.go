@@ -0,0 +1,59 @@
+package secretmgr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKvSecretDataSourceRead(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+
+	for _, v := range []string{"v1", "v2"} {
+		if err := addVersionedSecret("secret/a", &map[string]interface{}{"k": v}, meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.deleted["a"] = map[int]bool{1: true}
+
+	r := dataSourceKvSecret()
+
+	d := r.Data(nil)
+	d.Set("path", "secret/a")
+	if err := r.Read(d, meta); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Get("data.k"); got != "v2" {
+		t.Errorf("expected the latest version, got data.k = %v", got)
+	}
+	if got := d.Get("version"); got != 2 {
+		t.Errorf("expected version 2, got %v", got)
+	}
+
+	d = r.Data(nil)
+	d.Set("path", "secret/a")
+	d.Set("version", 1)
+	if err := r.Read(d, meta); err != nil {
+		t.Fatalf("expected a deleted version to be read, got %s", err)
+	}
+	if got := d.Get("data").(map[string]interface{}); len(got) != 0 {
+		t.Errorf("expected no data for a deleted version, got %v", got)
+	}
+	if got := d.Get("deletion_time"); got == "" {
+		t.Error("expected the deletion_time of the deleted version")
+	}
+
+	d = r.Data(nil)
+	d.Set("path", "secret/missing")
+	err := r.Read(d, meta)
+	if err == nil || !strings.Contains(err.Error(), "no secret found") {
+		t.Fatalf("expected a missing secret error, got %v", err)
+	}
+
+	d = r.Data(nil)
+	d.Set("path", "secret/missing")
+	d.Set("allow_missing", true)
+	if err := r.Read(d, meta); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,212 @@
+package secretmgr
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// Deletion modes of KV v2 secrets. KV v1 secrets are always deleted for good.
+const (
+	// deletionModeSoft deletes the latest version, which can be undeleted.
+	deletionModeSoft = "soft"
+	// deletionModeDestroy destroys every version but keeps the metadata.
+	deletionModeDestroy = "destroy"
+	// deletionModePurge deletes the metadata along with every version.
+	deletionModePurge = "purge"
+)
+
+var deletionModes = []string{deletionModeSoft, deletionModeDestroy, deletionModePurge}
+
+// resourceDeletionMode returns the deletion_mode of d, or else the provider
+// deletion_mode.
+func resourceDeletionMode(d *schema.ResourceData, meta *ProviderMeta) string {
+	if mode := d.Get("deletion_mode").(string); mode != "" {
+		return mode
+	}
+	return meta.deletionMode
+}
+
+// deleteSecret deletes the secret at the logical path p according to mode.
+func deleteSecret(p, mode string, meta *ProviderMeta) error {
+	mountPath, v2, err := isKVv2(p, meta)
+	if err != nil {
+		return fmt.Errorf("error determining if it's a v2 path: %s", err)
+	}
+
+	client := meta.client
+
+	if !v2 {
+		log.Printf("[DEBUG] deleting %s from Vault", p)
+		if _, err := client.Logical().Delete(p); err != nil {
+			return fmt.Errorf("error deleting %q from Vault: %s", p, err)
+		}
+		return nil
+	}
+
+	switch mode {
+	case deletionModeSoft:
+		dataPath := addPrefixToVKVPath(p, mountPath, "data")
+		log.Printf("[DEBUG] deleting latest version of %s from Vault", dataPath)
+		if _, err := client.Logical().Delete(dataPath); err != nil {
+			return fmt.Errorf("error deleting %q from Vault: %s", dataPath, err)
+		}
+
+	case deletionModeDestroy:
+		metadata, err := readSecretMetadata(p, meta)
+		if err != nil {
+			return err
+		}
+		if metadata == nil {
+			return nil
+		}
+
+		var versions []int
+		allVersions, _ := metadata["versions"].(map[string]interface{})
+		for k, v := range allVersions {
+			if version, ok := v.(map[string]interface{}); ok && version["destroyed"] == true {
+				continue
+			}
+			n, err := strconv.Atoi(k)
+			if err != nil {
+				return fmt.Errorf("error parsing version %q of %q: %s", k, p, err)
+			}
+			versions = append(versions, n)
+		}
+		if len(versions) == 0 {
+			return nil
+		}
+		sort.Ints(versions)
+
+		destroyPath := addPrefixToVKVPath(p, mountPath, "destroy")
+		log.Printf("[DEBUG] destroying versions %v of %s in Vault", versions, destroyPath)
+		_, err = client.Logical().Write(destroyPath, map[string]interface{}{
+			"versions": versions,
+		})
+		if err != nil {
+			return fmt.Errorf("error destroying %q in Vault: %s", destroyPath, err)
+		}
+
+	case deletionModePurge:
+		metadataPath := addPrefixToVKVPath(p, mountPath, "metadata")
+		log.Printf("[DEBUG] deleting %s from Vault", metadataPath)
+		if _, err := client.Logical().Delete(metadataPath); err != nil {
+			return fmt.Errorf("error deleting %q from Vault: %s", metadataPath, err)
+		}
+
+	default:
+		return fmt.Errorf("unknown deletion mode %q", mode)
+	}
+
+	return nil
+}
+
+// maxListedSecrets bounds the number of secrets listed in error messages.
+const maxListedSecrets = 50
+
+// formatSecretList returns paths one per line, cut after maxListedSecrets.
+func formatSecretList(paths []string) string {
+	if len(paths) == 0 {
+		return "  (none)"
+	}
+
+	listed := paths
+	if len(listed) > maxListedSecrets {
+		listed = listed[:maxListedSecrets]
+	}
+
+	s := "  " + strings.Join(listed, "\n  ")
+	if len(paths) > len(listed) {
+		s += fmt.Sprintf("\n  ... and %d more", len(paths)-len(listed))
+	}
+	return s
+}
+
+// deleteParallelism bounds the number of secrets a cascade works on at once.
+const deleteParallelism = 8
+
+// walkSecretTree calls fn, deleteParallelism at a time, on every secret under
+// the logical path root, or on root itself when it is not a directory. The
+// errors of fn are aggregated.
+func walkSecretTree(root string, meta *ProviderMeta, fn func(p string) error) error {
+	secrets, _, err := listSecretTree(root, 0, meta)
+	if err != nil {
+		return err
+	}
+	if len(secrets) == 0 {
+		secrets = []string{root}
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		result *multierror.Error
+	)
+	sem := make(chan struct{}, deleteParallelism)
+
+	for _, p := range secrets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(p string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := fn(p); err != nil {
+				mu.Lock()
+				result = multierror.Append(result, err)
+				mu.Unlock()
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	return result.ErrorOrNil()
+}
+
+// deleteSecretCascade deletes every secret under the logical path root
+// according to mode, or root itself when it is not a directory, then checks
+// that none of them can be read anymore.
+func deleteSecretCascade(root, mode string, meta *ProviderMeta) error {
+	err := walkSecretTree(root, meta, func(p string) error {
+		return deleteSecret(p, mode, meta)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Soft deleted and destroyed secrets are still listed, only their data is
+	// gone.
+	var (
+		mu   sync.Mutex
+		left []string
+	)
+	err = walkSecretTree(root, meta, func(p string) error {
+		secret, err := versionedSecret(latestSecretVersion, p, meta)
+		if err != nil {
+			return fmt.Errorf("error reading %q from Vault: %s", p, err)
+		}
+		if secret != nil {
+			mu.Lock()
+			left = append(left, p)
+			mu.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(left) > 0 {
+		sort.Strings(left)
+		return fmt.Errorf("%d secrets under %q are still readable after deletion: %s",
+			len(left), root, strings.Join(left, ", "))
+	}
+
+	return nil
+}
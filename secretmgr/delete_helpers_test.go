@@ -0,0 +1,202 @@
+package secretmgr
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestUserCheckDelete(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+
+	for _, p := range []string{"kv1/users/alice/example", "kv1/users/alice/ssh/key"} {
+		f.v1[p] = map[string]interface{}{"value": "x"}
+	}
+
+	cases := []struct {
+		name string
+		path string
+		raw  map[string]interface{}
+		err  string
+	}{
+		{name: "unprotected", path: "kv1/users/alice", raw: map[string]interface{}{}},
+		{name: "protected", path: "kv1/users/alice", raw: map[string]interface{}{"deletion_protection": true},
+			err: "delete these 2 secrets:\n  kv1/users/alice/example\n  kv1/users/alice/ssh/key"},
+		{name: "protected empty", path: "kv1/users/bob", raw: map[string]interface{}{"deletion_protection": true},
+			err: "delete these 0 secrets:\n  (none)"},
+		{name: "within max_delete_count", path: "kv1/users/alice", raw: map[string]interface{}{"max_delete_count": 2}},
+		{name: "over max_delete_count", path: "kv1/users/alice", raw: map[string]interface{}{"max_delete_count": 1},
+			err: "would delete 2 secrets, more than max_delete_count (1)"},
+		{name: "empty with max_delete_count", path: "kv1/users/bob", raw: map[string]interface{}{"max_delete_count": 1}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d := schema.TestResourceDataRaw(t, resourceUser().Schema, c.raw)
+			err := userCheckDelete(d, c.path, meta)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("expected an error containing %q, got %v", c.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestWalkSecretTree(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+	f.v1["kv1/users/alice/example"] = map[string]interface{}{"value": "x"}
+	f.v1["kv1/users/alice/ssh/key"] = map[string]interface{}{"value": "x"}
+
+	for root, expected := range map[string][]string{
+		"kv1/users/alice":         {"kv1/users/alice/example", "kv1/users/alice/ssh/key"},
+		"kv1/users/alice/example": {"kv1/users/alice/example"},
+	} {
+		var (
+			mu      sync.Mutex
+			visited []string
+		)
+		err := walkSecretTree(root, meta, func(p string) error {
+			mu.Lock()
+			visited = append(visited, p)
+			mu.Unlock()
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(visited)
+		if strings.Join(visited, ",") != strings.Join(expected, ",") {
+			t.Errorf("walkSecretTree(%q) visited %v, expected %v", root, visited, expected)
+		}
+	}
+}
+
+func TestDeleteSecret(t *testing.T) {
+	for _, mode := range deletionModes {
+		t.Run(mode, func(t *testing.T) {
+			f := newFakeKV(t)
+			meta := f.meta()
+
+			for _, v := range []string{"v1", "v2", "v3"} {
+				if err := addVersionedSecret("secret/a", &map[string]interface{}{"k": v}, meta); err != nil {
+					t.Fatal(err)
+				}
+			}
+			markVersions(f.destroyed, "a", []int{1}, true)
+
+			if err := deleteSecret("secret/a", mode, meta); err != nil {
+				t.Fatal(err)
+			}
+
+			switch mode {
+			case deletionModeSoft:
+				if !f.deleted["a"][3] || f.deleted["a"][2] || f.destroyed["a"][3] {
+					t.Fatalf("expected only the latest version to be deleted, got deleted %v, destroyed %v", f.deleted["a"], f.destroyed["a"])
+				}
+				secret, err := versionedSecret(2, "secret/a", meta)
+				if err != nil || secret == nil {
+					t.Fatalf("expected version 2 to be kept, got %v, %v", secret, err)
+				}
+			case deletionModeDestroy:
+				for version := 1; version <= 3; version++ {
+					if !f.destroyed["a"][version] {
+						t.Fatalf("expected version %d to be destroyed, got %v", version, f.destroyed["a"])
+					}
+				}
+				metadata, err := readSecretMetadata("secret/a", meta)
+				if err != nil || metadata == nil {
+					t.Fatalf("expected the metadata to be kept, got %v, %v", metadata, err)
+				}
+			case deletionModePurge:
+				if _, ok := f.v2["a"]; ok {
+					t.Fatal("expected every version to be deleted")
+				}
+				metadata, err := readSecretMetadata("secret/a", meta)
+				if err != nil || metadata != nil {
+					t.Fatalf("expected the metadata to be deleted, got %v, %v", metadata, err)
+				}
+			}
+
+			secret, err := versionedSecret(latestSecretVersion, "secret/a", meta)
+			if err != nil || secret != nil {
+				t.Fatalf("expected secret/a not to be readable, got %v, %v", secret, err)
+			}
+		})
+	}
+}
+
+func TestDeleteSecretDestroyMissing(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+
+	if err := deleteSecret("secret/missing", deletionModeDestroy, meta); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.destroyed["missing"]; ok {
+		t.Fatal("expected nothing to be destroyed")
+	}
+}
+
+func TestDeleteSecretKVv1(t *testing.T) {
+	for _, mode := range deletionModes {
+		t.Run(mode, func(t *testing.T) {
+			f := newFakeKV(t)
+			meta := f.meta()
+			f.v1["kv1/a"] = map[string]interface{}{"k": "v"}
+
+			if err := deleteSecret("kv1/a", mode, meta); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := f.v1["kv1/a"]; ok {
+				t.Fatalf("expected deletion_mode %s to delete kv1/a", mode)
+			}
+		})
+	}
+}
+
+func TestDeleteSecretUnknownMode(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+
+	err := deleteSecret("secret/a", "shred", meta)
+	if err == nil || !strings.Contains(err.Error(), "unknown deletion mode") {
+		t.Fatalf("expected an unknown deletion mode error, got %v", err)
+	}
+}
+
+func TestDeleteSecretCascade(t *testing.T) {
+	for _, mode := range deletionModes {
+		t.Run(mode, func(t *testing.T) {
+			f := newFakeKV(t)
+			meta := f.meta()
+
+			for _, p := range []string{"secret/users/alice/example", "secret/users/alice/ssh/key", "secret/users/bob/example"} {
+				if err := addVersionedSecret(p, &map[string]interface{}{"k": "v"}, meta); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := deleteSecretCascade("secret/users/alice", mode, meta); err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range []string{"secret/users/alice/example", "secret/users/alice/ssh/key"} {
+				if secret, err := versionedSecret(latestSecretVersion, p, meta); err != nil || secret != nil {
+					t.Fatalf("expected %s to be deleted, got %v, %v", p, secret, err)
+				}
+			}
+			if secret, err := versionedSecret(latestSecretVersion, "secret/users/bob/example", meta); err != nil || secret == nil {
+				t.Fatalf("expected secret/users/bob/example to be kept, got %v, %v", secret, err)
+			}
+		})
+	}
+}
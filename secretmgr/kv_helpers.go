@@ -1,28 +1,72 @@
 package secretmgr
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"path"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/vault/api"
 )
 
-func versionedSecret(requestedVersion int, path string, client *api.Client) (*api.Secret, error) {
-	mountPath, v2, err := isKVv2(path, client)
+// namespaceHeader is the header Vault Enterprise reads the request namespace
+// from.
+const namespaceHeader = "X-Vault-Namespace"
+
+// clientNamespace returns the namespace the client sends its requests to.
+func clientNamespace(client *api.Client) string {
+	return client.Headers().Get(namespaceHeader)
+}
+
+// resourceIDSeparator separates the namespace from the path in resource IDs.
+// Vault cleans request paths, so it cannot appear in the path of a secret.
+const resourceIDSeparator = "//"
+
+// resourceID builds a resource ID from the namespace and path of a secret.
+// Secrets outside the root namespace always record their namespace in the ID,
+// so that it does not depend on the provider namespace of later runs. Secrets
+// in the root namespace use their path as ID, like they always did, unless
+// defaultNamespace, the provider namespace, is not the root one.
+func resourceID(namespace, defaultNamespace, path string) string {
+	namespace = strings.Trim(namespace, "/")
+	if namespace == "" && defaultNamespace == "" {
+		return path
+	}
+	return namespace + resourceIDSeparator + path
+}
+
+// parseResourceID splits a resource ID built by resourceID into its
+// namespace and path. ok is false for an ID without namespace, which is
+// either in the root namespace or a legacy ID of a secret in the provider
+// namespace.
+func parseResourceID(id string) (namespace string, path string, ok bool) {
+	if i := strings.Index(id, resourceIDSeparator); i >= 0 {
+		return id[:i], id[i+len(resourceIDSeparator):], true
+	}
+	return "", id, false
+}
+
+func versionedSecret(requestedVersion int, path string, meta *ProviderMeta) (*api.Secret, error) {
+	secret, _, err := versionedSecretWithMetadata(requestedVersion, path, meta)
+	return secret, err
+}
+
+// versionedSecretWithMetadata reads a secret like versionedSecret, also
+// returning the metadata of the version read on KV v2 mounts.
+func versionedSecretWithMetadata(requestedVersion int, path string, meta *ProviderMeta) (*api.Secret, map[string]interface{}, error) {
+	mountPath, v2, err := isKVv2(path, meta)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	var versionParam map[string]string
 
 	if v2 {
 		path = addPrefixToVKVPath(path, mountPath, "data")
-		if err != nil {
-			return nil, err
-		}
 
 		if requestedVersion > 0 {
 			versionParam = map[string]string{
@@ -31,25 +75,67 @@ func versionedSecret(requestedVersion int, path string, client *api.Client) (*ap
 		}
 	}
 
-	secret, err := kvReadRequest(client, path, versionParam)
+	secret, err := kvReadRequest(meta, path, versionParam)
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
+	var metadata map[string]interface{}
+
 	if v2 && secret != nil {
-		// This is a v2, grab the data field
-		if data, ok := secret.Data["data"]; ok && data != nil {
-			if dataMap, ok := data.(map[string]interface{}); ok {
-				secret.Data = dataMap
-			}
+		metadata, _ = secret.Data["metadata"].(map[string]interface{})
+
+		// This is a v2, grab the data field. Deleted and destroyed versions
+		// come back with metadata only.
+		data, ok := secret.Data["data"]
+		if !ok || data == nil {
+			return nil, metadata, nil
+		}
+		if dataMap, ok := data.(map[string]interface{}); ok {
+			secret.Data = dataMap
 		}
 	}
 
-	return secret, nil
+	return secret, metadata, nil
 }
 
-func kvReadRequest(client *api.Client, path string, params map[string]string) (*api.Secret, error) {
+// versionDeleted reports whether a version of a KV v2 secret is deleted,
+// according to its metadata. Secrets with a delete_version_after give their
+// versions a deletion_time in the future: they are only deleted once it has
+// passed.
+func versionDeleted(versionMetadata map[string]interface{}) bool {
+	deletionTime, _ := versionMetadata["deletion_time"].(string)
+	if deletionTime == "" {
+		return false
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, deletionTime)
+	if err != nil {
+		log.Printf("[WARN] Invalid deletion_time %q, assuming the version is deleted", deletionTime)
+		return true
+	}
+	return !t.After(time.Now())
+}
+
+// metadataInt returns an integer field of KV v2 metadata, which the API client
+// decodes as a json.Number.
+func metadataInt(metadata map[string]interface{}, key string) int {
+	switch v := metadata[key].(type) {
+	case json.Number:
+		i, _ := v.Int64()
+		return int(i)
+	case float64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+func kvReadRequest(meta *ProviderMeta, path string, params map[string]string) (*api.Secret, error) {
+	client := meta.client
+
 	r := client.NewRequest("GET", "/v1/"+path)
 	for k, v := range params {
 		r.Params.Set(k, v)
@@ -58,6 +144,9 @@ func kvReadRequest(client *api.Client, path string, params map[string]string) (*
 	if resp != nil {
 		defer resp.Body.Close()
 	}
+	if isMountMissing(err) {
+		meta.mountCache.invalidate(meta.namespace, path)
+	}
 	if resp != nil && (resp.StatusCode == 403 || resp.StatusCode == 404) {
 		secret, parseErr := api.ParseSecret(resp.Body)
 		switch parseErr {
@@ -79,7 +168,9 @@ func kvReadRequest(client *api.Client, path string, params map[string]string) (*
 	return api.ParseSecret(resp.Body)
 }
 
-func kvPreflightVersionRequest(client *api.Client, path string) (string, int, error) {
+func kvPreflightVersionRequest(meta *ProviderMeta, path string) (string, int, error) {
+	client := meta.client
+
 	// We don't want to use a wrapping call here so save any custom value and
 	// restore after
 	currentWrappingLookupFunc := client.CurrentWrappingLookupFunc()
@@ -128,11 +219,16 @@ func kvPreflightVersionRequest(client *api.Client, path string) (string, int, er
 	return mountPath, 1, nil
 }
 
-func isKVv2(path string, client *api.Client) (string, bool, error) {
-	mountPath, version, err := kvPreflightVersionRequest(client, path)
+func isKVv2(path string, meta *ProviderMeta) (string, bool, error) {
+	if mountPath, version, ok := meta.mountCache.lookup(meta.namespace, path); ok {
+		return mountPath, version == 2, nil
+	}
+
+	mountPath, version, err := kvPreflightVersionRequest(meta, path)
 	if err != nil {
 		return "", false, err
 	}
+	meta.mountCache.store(meta.namespace, mountPath, version)
 
 	return mountPath, version == 2, nil
 }
@@ -147,23 +243,31 @@ func addPrefixToVKVPath(p, mountPath, apiPrefix string) string {
 	}
 }
 
-func addVersionedSecret(path string, payLoad *map[string]interface{}, client *api.Client) error {
-	mountPath, v2, err := isKVv2(path, client)
+func addVersionedSecret(path string, payLoad *map[string]interface{}, meta *ProviderMeta) error {
+	_, err := writeVersionedSecret(path, payLoad, nil, meta)
+	return err
+}
+
+// writeVersionedSecret writes payLoad to path, sending options such as cas
+// along on KV v2 mounts. On KV v2 mounts the response holds the metadata of
+// the version written.
+func writeVersionedSecret(path string, payLoad *map[string]interface{}, options map[string]interface{}, meta *ProviderMeta) (*api.Secret, error) {
+	mountPath, v2, err := isKVv2(path, meta)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var data map[string]interface{}
 
 	if v2 {
 		path = addPrefixToVKVPath(path, mountPath, "data")
-		if err != nil {
-			return err
-		}
 
+		if options == nil {
+			options = map[string]interface{}{}
+		}
 		data = map[string]interface{}{
 			"data":    payLoad,
-			"options": map[string]interface{}{},
+			"options": options,
 		}
 	} else {
 		data = *payLoad
@@ -171,45 +275,143 @@ func addVersionedSecret(path string, payLoad *map[string]interface{}, client *ap
 
 	log.Printf("[DEBUG] Writing generic Vault secret to %s", path)
 
-	_, err = client.Logical().Write(path, data)
-	return err
+	secret, err := meta.client.Logical().Write(path, data)
+	if isMountMissing(err) {
+		meta.mountCache.invalidate(meta.namespace, path)
+	}
+	return secret, err
 }
 
-func deleteSecretCascade(deletePath string, meta interface{}) error {
+// isCASMismatch reports whether err is Vault's answer to a KV v2 write whose
+// cas option does not match the current version of the secret.
+func isCASMismatch(err error) bool {
+	respErr, ok := err.(*api.ResponseError)
+	if !ok || respErr.StatusCode != 400 {
+		return false
+	}
+	for _, e := range respErr.Errors {
+		if strings.Contains(e, "check-and-set parameter did not match") {
+			return true
+		}
+	}
+	return false
+}
 
-	client := meta.(*api.Client)
+// kvMetadataPath returns the path of the KV v2 metadata of a secret, failing on
+// KV v1 mounts which have no metadata.
+func kvMetadataPath(path string, meta *ProviderMeta) (string, error) {
+	mountPath, v2, err := isKVv2(path, meta)
+	if err != nil {
+		return "", err
+	}
+	if !v2 {
+		return "", fmt.Errorf("%q is not on a KV v2 mount, it has no metadata", path)
+	}
 
-	log.Printf("[DEBUG] listing %s from Vault", deletePath)
+	return addPrefixToVKVPath(path, mountPath, "metadata"), nil
+}
 
-	secret, err := client.Logical().List(deletePath)
+// readSecretMetadata returns the KV v2 metadata of a secret, or nil when the
+// secret has none.
+func readSecretMetadata(path string, meta *ProviderMeta) (map[string]interface{}, error) {
+	metadataPath, err := kvMetadataPath(path, meta)
 	if err != nil {
-		return fmt.Errorf("error listing %q from Vault: %q", deletePath, err)
+		return nil, err
 	}
 
+	log.Printf("[DEBUG] Reading %s from Vault", metadataPath)
+
+	secret, err := meta.client.Logical().Read(metadataPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %q from Vault: %s", metadataPath, err)
+	}
 	if secret == nil {
-		_, err = client.Logical().Delete(deletePath)
+		return nil, nil
+	}
+
+	return secret.Data, nil
+}
+
+// writeSecretMetadata writes the max_versions, cas_required,
+// delete_version_after and custom_metadata settings of a KV v2 secret.
+func writeSecretMetadata(path string, settings map[string]interface{}, meta *ProviderMeta) error {
+	metadataPath, err := kvMetadataPath(path, meta)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{
+		"max_versions":         settings["max_versions"],
+		"cas_required":         settings["cas_required"],
+		"delete_version_after": settings["delete_version_after"],
+		"custom_metadata":      settings["custom_metadata"],
+	}
+
+	log.Printf("[DEBUG] Writing %s to Vault", metadataPath)
+
+	_, err = meta.client.Logical().Write(metadataPath, data)
+	if err != nil {
+		return fmt.Errorf("error writing %q to Vault: %s", metadataPath, err)
+	}
+
+	return nil
+}
+
+// listSecretTree lists the secrets and sub-directories under root, going at
+// most maxDepth directories deep, or all the way down when maxDepth is 0. The
+// paths returned are full logical paths, sorted.
+func listSecretTree(root string, maxDepth int, meta *ProviderMeta) ([]string, []string, error) {
+	mountPath, v2, err := isKVv2(root, meta)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var secrets, directories []string
+
+	var walk func(dir string, depth int) error
+	walk = func(dir string, depth int) error {
+		listPath := dir
+		if v2 {
+			listPath = addPrefixToVKVPath(dir, mountPath, "metadata")
+		}
+
+		log.Printf("[DEBUG] listing %s from Vault", listPath)
+
+		secret, err := meta.client.Logical().List(listPath)
 		if err != nil {
-			return fmt.Errorf("error deleting %q from Vault: %q", deletePath, err)
+			return fmt.Errorf("error listing %q from Vault: %s", listPath, err)
+		}
+		if secret == nil {
+			return nil
 		}
-	} else {
 
-		for _, v := range secret.Data["keys"].([]interface{}) {
+		keys, _ := secret.Data["keys"].([]interface{})
+		for _, v := range keys {
 			key := v.(string)
+			subPath := path.Join(dir, key)
 
-			log.Printf("[DEBUG] key - %s ", key)
+			if !strings.HasSuffix(key, "/") {
+				secrets = append(secrets, subPath)
+				continue
+			}
 
-			if key[len(key)-1:] == "/" {
-				userResourceDeleteAll(path.Join(deletePath, key[:len(key)-1]), meta)
-			} else {
-				subPath := path.Join(deletePath, key)
-				log.Printf("[DEBUG] deleting %s from Vault", subPath)
-				_, err = client.Logical().Delete(subPath)
-				if err != nil {
-					return fmt.Errorf("error deleting %q from Vault: %q", subPath, err)
+			directories = append(directories, subPath)
+			if maxDepth <= 0 || depth < maxDepth {
+				if err := walk(subPath, depth+1); err != nil {
+					return err
 				}
 			}
 		}
+
+		return nil
 	}
 
-	return nil
+	if err := walk(strings.TrimSuffix(root, "/"), 1); err != nil {
+		return nil, nil, err
+	}
+
+	sort.Strings(secrets)
+	sort.Strings(directories)
+
+	return secrets, directories, nil
 }
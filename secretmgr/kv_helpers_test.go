@@ -0,0 +1,500 @@
+package secretmgr
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKV is a stand-in Vault server with a KV v2 mount at secret/ and a KV v1
+// mount at kv1/.
+type fakeKV struct {
+	t *testing.T
+
+	mu         sync.Mutex
+	v2         map[string][]map[string]interface{}
+	deleted    map[string]map[int]bool
+	destroyed  map[string]map[int]bool
+	settings   map[string]map[string]interface{}
+	v1         map[string]map[string]interface{}
+	disabled   bool
+	preflights int
+	namespaces []string
+
+	// requests logs the method and path of every request.
+	requests []string
+	// beforeRequest, if set, is called with f locked before every request
+	// is handled.
+	beforeRequest func(method, p string)
+}
+
+func newFakeKV(t *testing.T) *fakeKV {
+	return &fakeKV{
+		t:         t,
+		v2:        make(map[string][]map[string]interface{}),
+		deleted:   make(map[string]map[int]bool),
+		destroyed: make(map[string]map[int]bool),
+		settings:  make(map[string]map[string]interface{}),
+		v1:        make(map[string]map[string]interface{}),
+	}
+}
+
+// pastDeletionTime is the deletion_time of the versions deleted in a fakeKV.
+const pastDeletionTime = "2020-10-01T00:00:00Z"
+
+// versionMetadata returns the metadata of a version of the KV v2 secret key.
+// Versions not deleted yet get a deletion_time in the future when the secret
+// has a delete_version_after, like in Vault.
+func (f *fakeKV) versionMetadata(key string, version int) map[string]interface{} {
+	metadata := map[string]interface{}{
+		"version":       version,
+		"created_time":  "2020-09-01T00:00:00Z",
+		"deletion_time": "",
+		"destroyed":     f.destroyed[key][version],
+	}
+	if f.deleted[key][version] || f.destroyed[key][version] {
+		metadata["deletion_time"] = pastDeletionTime
+	} else if after, _ := f.settings[key]["delete_version_after"].(string); after != "" {
+		if d, err := time.ParseDuration(after); err == nil && d > 0 {
+			metadata["deletion_time"] = time.Now().Add(d).UTC().Format(time.RFC3339Nano)
+		}
+	}
+	return metadata
+}
+
+// versionNumbers returns the versions listed in the versions parameter of
+// body.
+func versionNumbers(body map[string]interface{}) []int {
+	var versions []int
+	list, _ := body["versions"].([]interface{})
+	for _, v := range list {
+		if n, ok := v.(float64); ok {
+			versions = append(versions, int(n))
+		}
+	}
+	return versions
+}
+
+func markVersions(marks map[string]map[int]bool, key string, versions []int, mark bool) {
+	if marks[key] == nil {
+		marks[key] = make(map[int]bool)
+	}
+	for _, version := range versions {
+		marks[key][version] = mark
+	}
+}
+
+// meta returns a meta of a client of f with mount caching enabled and the
+// default deletion_mode of the provider.
+func (f *fakeKV) meta() *ProviderMeta {
+	meta := newProviderMeta(testClient(f.t, f))
+	meta.mountCache = newMountCache()
+	meta.deletionMode = deletionModePurge
+	return meta
+}
+
+func (f *fakeKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.namespaces = append(f.namespaces, r.Header.Get(namespaceHeader))
+	p := strings.TrimPrefix(r.URL.Path, "/v1/")
+	f.requests = append(f.requests, r.Method+" "+p)
+	if f.beforeRequest != nil {
+		f.beforeRequest(r.Method, p)
+	}
+
+	var body map[string]interface{}
+	if r.Method == "PUT" || r.Method == "POST" {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			f.t.Errorf("error decoding request to %s: %s", p, err)
+		}
+	}
+
+	noHandler := map[string]interface{}{"errors": []string{"1 error occurred:\n\t* unsupported path\n\n", "no handler for route"}}
+	notFound := map[string]interface{}{"errors": []string{}}
+
+	if r.URL.Query().Get("list") == "true" {
+		var keys []interface{}
+		switch {
+		case strings.HasPrefix(p, "secret/metadata/") && !f.disabled:
+			keys = f.list(strings.TrimPrefix(p, "secret/metadata/"), f.v2Keys())
+		case strings.HasPrefix(p, "kv1/"):
+			keys = f.list(p, f.v1Keys())
+		}
+		if len(keys) == 0 {
+			writeTestJSON(w, 404, notFound)
+			return
+		}
+		writeTestJSON(w, 200, map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(p, "sys/internal/ui/mounts/"):
+		f.preflights++
+		p = strings.TrimPrefix(p, "sys/internal/ui/mounts/")
+		switch {
+		case strings.HasPrefix(p, "secret/") && !f.disabled:
+			writeTestJSON(w, 200, map[string]interface{}{"data": map[string]interface{}{
+				"path": "secret/", "options": map[string]interface{}{"version": "2"},
+			}})
+		case strings.HasPrefix(p, "kv1/"):
+			writeTestJSON(w, 200, map[string]interface{}{"data": map[string]interface{}{
+				"path": "kv1/", "options": nil,
+			}})
+		default:
+			writeTestJSON(w, 400, noHandler)
+		}
+
+	case strings.HasPrefix(p, "secret/data/"):
+		if f.disabled {
+			writeTestJSON(w, 404, noHandler)
+			return
+		}
+		key := strings.TrimPrefix(p, "secret/data/")
+		versions := f.v2[key]
+
+		switch r.Method {
+		case "PUT", "POST":
+			options, _ := body["options"].(map[string]interface{})
+			if cas, ok := options["cas"].(float64); ok && int(cas) != len(versions) {
+				writeTestJSON(w, 400, map[string]interface{}{"errors": []string{"check-and-set parameter did not match the current version"}})
+				return
+			}
+			data, _ := body["data"].(map[string]interface{})
+			f.v2[key] = append(versions, data)
+			writeTestJSON(w, 200, map[string]interface{}{"data": map[string]interface{}{"version": len(f.v2[key])}})
+
+		case "GET":
+			version := len(versions)
+			if v := r.URL.Query().Get("version"); v != "" {
+				version, _ = strconv.Atoi(v)
+			}
+			if version < 1 || version > len(versions) {
+				writeTestJSON(w, 404, notFound)
+				return
+			}
+			metadata := f.versionMetadata(key, version)
+			if f.deleted[key][version] || f.destroyed[key][version] {
+				writeTestJSON(w, 404, map[string]interface{}{"data": map[string]interface{}{"data": nil, "metadata": metadata}})
+				return
+			}
+			writeTestJSON(w, 200, map[string]interface{}{"data": map[string]interface{}{"data": versions[version-1], "metadata": metadata}})
+
+		case "DELETE":
+			if len(versions) > 0 {
+				markVersions(f.deleted, key, []int{len(versions)}, true)
+			}
+			w.WriteHeader(204)
+		}
+
+	case strings.HasPrefix(p, "secret/metadata/") && !f.disabled:
+		key := strings.TrimPrefix(p, "secret/metadata/")
+
+		switch r.Method {
+		case "PUT", "POST":
+			f.settings[key] = body
+			w.WriteHeader(204)
+
+		case "DELETE":
+			delete(f.v2, key)
+			delete(f.deleted, key)
+			delete(f.destroyed, key)
+			delete(f.settings, key)
+			w.WriteHeader(204)
+
+		case "GET":
+			versions := f.v2[key]
+			settings, ok := f.settings[key]
+			if len(versions) == 0 && !ok {
+				writeTestJSON(w, 404, notFound)
+				return
+			}
+
+			allVersions := make(map[string]interface{})
+			for version := 1; version <= len(versions); version++ {
+				allVersions[strconv.Itoa(version)] = f.versionMetadata(key, version)
+			}
+			data := map[string]interface{}{
+				"current_version":      len(versions),
+				"max_versions":         0,
+				"cas_required":         false,
+				"delete_version_after": "0s",
+				"custom_metadata":      nil,
+				"versions":             allVersions,
+			}
+			for k, v := range settings {
+				data[k] = v
+			}
+			writeTestJSON(w, 200, map[string]interface{}{"data": data})
+		}
+
+	case strings.HasPrefix(p, "secret/destroy/") && !f.disabled:
+		markVersions(f.destroyed, strings.TrimPrefix(p, "secret/destroy/"), versionNumbers(body), true)
+		w.WriteHeader(204)
+
+	case strings.HasPrefix(p, "secret/undelete/") && !f.disabled:
+		markVersions(f.deleted, strings.TrimPrefix(p, "secret/undelete/"), versionNumbers(body), false)
+		w.WriteHeader(204)
+
+	case strings.HasPrefix(p, "kv1/"):
+		switch r.Method {
+		case "PUT", "POST":
+			f.v1[p] = body
+			w.WriteHeader(204)
+		case "DELETE":
+			delete(f.v1, p)
+			w.WriteHeader(204)
+		case "GET":
+			data, ok := f.v1[p]
+			if !ok {
+				writeTestJSON(w, 404, notFound)
+				return
+			}
+			writeTestJSON(w, 200, map[string]interface{}{"data": data})
+		}
+
+	default:
+		writeTestJSON(w, 404, noHandler)
+	}
+}
+
+func (f *fakeKV) v2Keys() []string {
+	var keys []string
+	for k := range f.v2 {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (f *fakeKV) v1Keys() []string {
+	var keys []string
+	for k := range f.v1 {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// list returns the keys directly under dir, directories with a trailing /.
+func (f *fakeKV) list(dir string, paths []string) []interface{} {
+	dir = strings.TrimSuffix(dir, "/") + "/"
+	seen := make(map[string]bool)
+
+	var keys []interface{}
+	for _, p := range paths {
+		if !strings.HasPrefix(p, dir) {
+			continue
+		}
+		key := strings.TrimPrefix(p, dir)
+		if i := strings.Index(key, "/"); i >= 0 {
+			key = key[:i+1]
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].(string) < keys[j].(string) })
+	return keys
+}
+
+func TestResourceID(t *testing.T) {
+	cases := []struct {
+		namespace, defaultNamespace, path string
+		id                                string
+	}{
+		{"", "", "secret/a", "secret/a"},
+		{"team", "team", "secret/a", "team//secret/a"},
+		{"team", "", "secret/a", "team//secret/a"},
+		{"team/sub/", "", "secret/a", "team/sub//secret/a"},
+		{"", "team", "secret/a", "//secret/a"},
+		{"", "", "secret/with:colon", "secret/with:colon"},
+	}
+
+	for _, c := range cases {
+		id := resourceID(c.namespace, c.defaultNamespace, c.path)
+		if id != c.id {
+			t.Errorf("resourceID(%q, %q, %q) = %q, expected %q", c.namespace, c.defaultNamespace, c.path, id, c.id)
+		}
+	}
+}
+
+func TestParseResourceID(t *testing.T) {
+	cases := []struct {
+		id              string
+		namespace, path string
+		ok              bool
+	}{
+		{"secret/a", "", "secret/a", false},
+		{"secret/with:colon", "", "secret/with:colon", false},
+		{"team//secret/a", "team", "secret/a", true},
+		{"team/sub//secret/a", "team/sub", "secret/a", true},
+		{"//secret/a", "", "secret/a", true},
+	}
+
+	for _, c := range cases {
+		namespace, path, ok := parseResourceID(c.id)
+		if namespace != c.namespace || path != c.path || ok != c.ok {
+			t.Errorf("parseResourceID(%q) = %q, %q, %v, expected %q, %q, %v",
+				c.id, namespace, path, ok, c.namespace, c.path, c.ok)
+		}
+	}
+}
+
+func TestIsKVv2(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+
+	cases := []struct {
+		path      string
+		mountPath string
+		v2        bool
+	}{
+		{"secret/a", "secret/", true},
+		{"secret/b/c", "secret/", true},
+		{"kv1/a", "kv1/", false},
+		{"kv1/b", "kv1/", false},
+	}
+	for _, c := range cases {
+		mountPath, v2, err := isKVv2(c.path, meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mountPath != c.mountPath || v2 != c.v2 {
+			t.Errorf("isKVv2(%q) = %q, %v, expected %q, %v", c.path, mountPath, v2, c.mountPath, c.v2)
+		}
+	}
+
+	if f.preflights != 2 {
+		t.Errorf("expected one preflight request per mount, got %d", f.preflights)
+	}
+}
+
+func TestIsKVv2WithoutMountCache(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+	meta.mountCache = nil
+
+	for _, p := range []string{"secret/a", "secret/b"} {
+		if _, _, err := isKVv2(p, meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if f.preflights != 2 {
+		t.Errorf("expected one preflight request per lookup, got %d", f.preflights)
+	}
+}
+
+func TestIsKVv2MountCacheInvalidation(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+
+	if err := addVersionedSecret("secret/a", &map[string]interface{}{"k": "v"}, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	// Disabling the mount makes the next read miss, which must forget the
+	// cached mount.
+	f.disabled = true
+	secret, err := versionedSecret(latestSecretVersion, "secret/a", meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != nil {
+		t.Fatalf("expected no secret on a disabled mount, got %v", secret)
+	}
+	if _, _, ok := meta.mountCache.lookup(meta.namespace, "secret/a"); ok {
+		t.Fatal("expected the disabled mount to be removed from the cache")
+	}
+
+	f.disabled = false
+	preflights := f.preflights
+	if _, _, err := isKVv2("secret/a", meta); err != nil {
+		t.Fatal(err)
+	}
+	if f.preflights != preflights+1 {
+		t.Errorf("expected a new preflight request after invalidation, got %d", f.preflights-preflights)
+	}
+}
+
+func TestWriteVersionedSecret(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+
+	secret, err := writeVersionedSecret("secret/a", &map[string]interface{}{"k": "v1"}, nil, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version := metadataInt(secret.Data, "version"); version != 1 {
+		t.Fatalf("expected version 1, got %d", version)
+	}
+
+	options := map[string]interface{}{"cas": 1}
+	if _, err := writeVersionedSecret("secret/a", &map[string]interface{}{"k": "v2"}, options, meta); err != nil {
+		t.Fatal(err)
+	}
+	_, err = writeVersionedSecret("secret/a", &map[string]interface{}{"k": "v3"}, options, meta)
+	if !isCASMismatch(err) {
+		t.Fatalf("expected a CAS mismatch, got %v", err)
+	}
+	if got := f.v2["a"]; len(got) != 2 || got[1]["k"] != "v2" {
+		t.Fatalf("unexpected versions of secret/a: %v", got)
+	}
+
+	if _, err := writeVersionedSecret("kv1/a", &map[string]interface{}{"k": "v1"}, options, meta); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.v1["kv1/a"]; len(got) != 1 || got["k"] != "v1" {
+		t.Fatalf("expected kv1/a to be written as is, got %v", got)
+	}
+}
+
+func TestVersionedSecret(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+
+	for _, v := range []string{"v1", "v2", "v3"} {
+		if err := addVersionedSecret("secret/a", &map[string]interface{}{"k": v}, meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := addVersionedSecret("kv1/a", &map[string]interface{}{"k": "v1"}, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path    string
+		version int
+		value   interface{}
+	}{
+		{"secret/a", latestSecretVersion, "v3"},
+		{"secret/a", 2, "v2"},
+		{"kv1/a", latestSecretVersion, "v1"},
+	}
+	for _, c := range cases {
+		secret, err := versionedSecret(c.version, c.path, meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if secret == nil || secret.Data["k"] != c.value {
+			t.Errorf("versionedSecret(%d, %q) = %v, expected k = %v", c.version, c.path, secret, c.value)
+		}
+	}
+
+	for _, p := range []string{"secret/missing", "kv1/missing"} {
+		secret, err := versionedSecret(latestSecretVersion, p, meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if secret != nil {
+			t.Errorf("expected no secret at %q, got %v", p, secret)
+		}
+	}
+}
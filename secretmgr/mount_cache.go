@@ -0,0 +1,87 @@
+package secretmgr
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// mountCache remembers the mount path and KV version returned by
+// kvPreflightVersionRequest, so every path under a mount is looked up once.
+// A nil *mountCache caches nothing.
+type mountCache struct {
+	mu     sync.RWMutex
+	mounts map[string]map[string]int
+}
+
+func newMountCache() *mountCache {
+	return &mountCache{mounts: make(map[string]map[string]int)}
+}
+
+// lookup returns the mount path and KV version of the cached mount that p
+// lives under in namespace.
+func (c *mountCache) lookup(namespace, p string) (string, int, bool) {
+	if c == nil {
+		return "", 0, false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for mountPath, version := range c.mounts[namespace] {
+		if isUnderMount(p, mountPath) {
+			return mountPath, version, true
+		}
+	}
+	return "", 0, false
+}
+
+func (c *mountCache) store(namespace, mountPath string, version int) {
+	if c == nil || mountPath == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.mounts[namespace] == nil {
+		c.mounts[namespace] = make(map[string]int)
+	}
+	c.mounts[namespace][mountPath] = version
+}
+
+// invalidate forgets the cached mount that p lives under in namespace.
+func (c *mountCache) invalidate(namespace, p string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for mountPath := range c.mounts[namespace] {
+		if isUnderMount(p, mountPath) {
+			delete(c.mounts[namespace], mountPath)
+		}
+	}
+}
+
+func isUnderMount(p, mountPath string) bool {
+	return p == strings.TrimSuffix(mountPath, "/") || strings.HasPrefix(p, mountPath)
+}
+
+// isMountMissing reports whether err is Vault's answer to a request on a path
+// that no mount handles, e.g. because the mount was disabled.
+func isMountMissing(err error) bool {
+	respErr, ok := err.(*api.ResponseError)
+	if !ok || respErr.StatusCode != 404 {
+		return false
+	}
+	for _, e := range respErr.Errors {
+		if strings.Contains(e, "no handler for route") {
+			return true
+		}
+	}
+	return false
+}
@@ -4,36 +4,295 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/command/config"
 )
 
+// childTokens tracks the child tokens created by providerConfigure so they
+// can be revoked when the plugin shuts down.
+var childTokens struct {
+	sync.Mutex
+	clients  []*api.Client
+	watchers []*api.LifetimeWatcher
+}
+
 // Provider -
 func Provider() *schema.Provider {
 	return &schema.Provider{
 		Schema: map[string]*schema.Schema{
 			"address": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("VAULT_ADDR", nil),
-				Description: "URL of the root of the target Vault server.",
+				Description: "URL of the root of the target Vault server. Ignored when addresses is set.",
+			},
+			"addresses": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MinItems:    1,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "URLs of the Vault servers to fail over between, in order of preference.",
+			},
+			"max_retries": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("VAULT_MAX_RETRIES", 2),
+				Description: "Maximum number of retries of requests failing with a 429 or 5xx response.",
+			},
+			"client_timeout": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     60,
+				Description: "Timeout in seconds of each request to Vault.",
+			},
+			"max_concurrent_requests": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     0,
+				Description: "Maximum number of requests in flight to Vault. 0 means unlimited.",
+			},
+			"rate_limit": {
+				Type:        schema.TypeFloat,
+				Optional:    true,
+				Default:     0,
+				Description: "Maximum number of requests per second sent to Vault. 0 means unlimited.",
+			},
+			"user_base_path": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "sre-secrets/users",
+				Description: "Default base_path of secretmgr_user resources.",
+			},
+			"deletion_mode": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      deletionModePurge,
+				ValidateFunc: validation.StringInSlice(deletionModes, false),
+				Description:  "Default deletion_mode of the resources: soft deletes the latest version, destroy destroys every version, purge also deletes the metadata. KV v1 secrets are always deleted.",
+			},
+			"redact_logs": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     true,
+				Description: "Hide the values of secrets from the provider debug logs.",
+			},
+			"disable_mount_cache": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Look up the mount and KV version of a path on every request instead of caching them.",
+			},
+			"namespace": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("VAULT_NAMESPACE", ""),
+				Description: "Vault Enterprise namespace used for all requests, including login.",
+			},
+			"ca_cert_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Path to a PEM-encoded CA certificate file used to verify the Vault server.",
+			},
+			"ca_cert_dir": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Path to a directory of PEM-encoded CA certificate files used to verify the Vault server.",
+			},
+			"client_auth": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "Client certificate presented to the Vault server.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"cert_file": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "Path to a PEM-encoded client certificate.",
+						},
+						"key_file": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "Path to the PEM-encoded private key of the client certificate.",
+						},
+					},
+				},
+			},
+			"tls_server_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Name to use as the SNI host when connecting via TLS.",
+			},
+			"skip_tls_verify": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Skip verification of the Vault server's TLS certificate.",
 			},
 			"username": &schema.Schema{
 				Type:        schema.TypeString,
 				Optional:    true,
 				Default:     "",
-				Description: "username",
+				Description: "Shorthand for an auth_login against auth/userpass/login/<username>.",
 			},
 			"password": &schema.Schema{
 				Type:        schema.TypeString,
 				Optional:    true,
 				Default:     "",
-				Description: "password",
+				Description: "Password for the userpass login.",
 				Sensitive:   true,
 			},
+			"auth_login": {
+				Type:          schema.TypeList,
+				Optional:      true,
+				MaxItems:      1,
+				ConflictsWith: authLoginConflicts("auth_login"),
+				Description:   "Login to Vault by writing parameters to an arbitrary auth login path.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"path": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "Login path, e.g. auth/userpass/login/<username>.",
+						},
+						"parameters": {
+							Type:        schema.TypeMap,
+							Optional:    true,
+							Elem:        &schema.Schema{Type: schema.TypeString},
+							Description: "Parameters sent to the login path.",
+							Sensitive:   true,
+						},
+					},
+				},
+			},
+			"auth_login_approle": {
+				Type:          schema.TypeList,
+				Optional:      true,
+				MaxItems:      1,
+				ConflictsWith: authLoginConflicts("auth_login_approle"),
+				Description:   "Login to Vault using the AppRole auth method.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"mount": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     "approle",
+							Description: "Path the AppRole auth method is mounted at.",
+						},
+						"role_id": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "RoleID of the AppRole to log in with.",
+						},
+						"secret_id": {
+							Type:          schema.TypeString,
+							Optional:      true,
+							ConflictsWith: []string{"auth_login_approle.0.wrapped_secret_id"},
+							Description:   "SecretID of the AppRole to log in with.",
+							Sensitive:     true,
+						},
+						"wrapped_secret_id": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Response-wrapping token unwrapped to get the SecretID to log in with.",
+							Sensitive:   true,
+						},
+					},
+				},
+			},
+			"auth_login_kubernetes": {
+				Type:          schema.TypeList,
+				Optional:      true,
+				MaxItems:      1,
+				ConflictsWith: authLoginConflicts("auth_login_kubernetes"),
+				Description:   "Login to Vault using the Kubernetes auth method.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"mount": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     "kubernetes",
+							Description: "Path the Kubernetes auth method is mounted at.",
+						},
+						"role": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "Name of the Kubernetes auth role to log in with.",
+						},
+						"jwt_file": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     "/var/run/secrets/kubernetes.io/serviceaccount/token",
+							Description: "Path of the service account JWT.",
+						},
+					},
+				},
+			},
+			"auth_login_cert": {
+				Type:          schema.TypeList,
+				Optional:      true,
+				MaxItems:      1,
+				ConflictsWith: authLoginConflicts("auth_login_cert"),
+				Description:   "Login to Vault using the TLS certificate auth method with the client_auth certificate.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"mount": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     "cert",
+							Description: "Path the TLS certificate auth method is mounted at.",
+						},
+						"name": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Name of the certificate role to log in with.",
+						},
+					},
+				},
+			},
+			"auth_login_jwt": {
+				Type:          schema.TypeList,
+				Optional:      true,
+				MaxItems:      1,
+				ConflictsWith: authLoginConflicts("auth_login_jwt"),
+				Description:   "Login to Vault using the JWT/OIDC auth method with a static JWT.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"mount": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     "jwt",
+							Description: "Path the JWT/OIDC auth method is mounted at.",
+						},
+						"role": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "Name of the JWT auth role to log in with.",
+						},
+						"jwt": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							DefaultFunc: schema.EnvDefaultFunc("TERRAFORM_VAULT_AUTH_JWT", nil),
+							Description: "JWT to log in with. Exactly one of jwt and jwt_file must be set.",
+							Sensitive:   true,
+						},
+						"jwt_file": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Path of a file containing the JWT to log in with. Exactly one of jwt and jwt_file must be set.",
+						},
+					},
+				},
+			},
 			"token": {
 				Type:        schema.TypeString,
 				Required:    true,
@@ -41,6 +300,13 @@ func Provider() *schema.Provider {
 				Description: "Token to use to authenticate to Vault.",
 				Sensitive:   true,
 			},
+			"wrapped_token": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("TERRAFORM_VAULT_WRAPPED_TOKEN", ""),
+				Description: "Response-wrapping token unwrapped to get the token used to authenticate to Vault.",
+				Sensitive:   true,
+			},
 			"max_lease_ttl_seconds": {
 				Type:     schema.TypeInt,
 				Optional: true,
@@ -53,18 +319,66 @@ func Provider() *schema.Provider {
 
 				Description: "Maximum TTL for secret leases requested by this provider",
 			},
+			"skip_child_token": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("TERRAFORM_VAULT_SKIP_CHILD_TOKEN", false),
+				Description: "Use the provided token directly instead of creating a limited child token.",
+			},
+			"child_token_policies": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Policies of the child token. Defaults to the policies of the parent token.",
+			},
+			"child_token_display_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "terraform",
+				Description: "Display name of the child token.",
+			},
+			"child_token_orphan": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Create the child token as an orphan token, so it outlives its parent.",
+			},
+			"child_token_renewable": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Create a renewable child token, renewed in the background while the provider runs.",
+			},
 		},
 		ResourcesMap: map[string]*schema.Resource{
 			"secretmgr_user":               resourceUser(),
 			"secretmgr_gpg":                resourceGpg(),
 			"secretmgr_decrypt_aws_secret": resourceDecryptAwsSecret(),
+			"secretmgr_kv_secret":          resourceKvSecret(),
+			"secretmgr_kv_metadata":        resourceKvMetadata(),
+			"secretmgr_kv_rollback":        resourceKvRollback(),
+			"secretmgr_kv_restore":         resourceKvRestore(),
+		},
+		DataSourcesMap: map[string]*schema.Resource{
+			"secretmgr_kv_secret": dataSourceKvSecret(),
+			"secretmgr_kv_list":   dataSourceKvList(),
 		},
-		DataSourcesMap: map[string]*schema.Resource{},
-		ConfigureFunc:  providerConfigure,
+		ConfigureFunc: providerConfigure,
 	}
 }
 
-func providerToken(d *schema.ResourceData) (string, error) {
+func providerToken(d *schema.ResourceData, client *api.Client) (string, error) {
+	if wrappedToken := d.Get("wrapped_token").(string); wrappedToken != "" {
+		secret, err := unwrapSecret(client, wrappedToken)
+		if err != nil {
+			return "", err
+		}
+		if secret.Auth == nil || secret.Auth.ClientToken == "" {
+			return "", errors.New("wrapped_token does not wrap a Vault token")
+		}
+		return secret.Auth.ClientToken, nil
+	}
+
 	if token := d.Get("token").(string); token != "" {
 		return token, nil
 	}
@@ -83,35 +397,96 @@ func providerToken(d *schema.ResourceData) (string, error) {
 
 func providerConfigure(d *schema.ResourceData) (interface{}, error) {
 	clientConfig := api.DefaultConfig()
-	addr := d.Get("address").(string)
-	if addr != "" {
-		clientConfig.Address = addr
+
+	var addresses []string
+	for _, addr := range d.Get("addresses").([]interface{}) {
+		addresses = append(addresses, addr.(string))
+	}
+	if len(addresses) > 0 {
+		if addr := d.Get("address").(string); addr != "" {
+			log.Printf("[DEBUG] Using addresses %v instead of address %s", addresses, addr)
+		}
+	} else {
+		addr := d.Get("address").(string)
+		if addr == "" {
+			return nil, errors.New("one of address or addresses must be set")
+		}
+		addresses = []string{addr}
+	}
+	clientConfig.Address = addresses[0]
+
+	tlsConfig := &api.TLSConfig{
+		CACert:        d.Get("ca_cert_file").(string),
+		CAPath:        d.Get("ca_cert_dir").(string),
+		TLSServerName: d.Get("tls_server_name").(string),
+		Insecure:      d.Get("skip_tls_verify").(bool),
+	}
+	if v, ok := d.GetOk("client_auth"); ok {
+		clientAuth := v.([]interface{})[0].(map[string]interface{})
+		tlsConfig.ClientCert = clientAuth["cert_file"].(string)
+		tlsConfig.ClientKey = clientAuth["key_file"].(string)
+	}
+	if err := clientConfig.ConfigureTLS(tlsConfig); err != nil {
+		return nil, fmt.Errorf("failed to configure TLS for Vault API: %s", err)
 	}
 
+	clientConfig.MaxRetries = d.Get("max_retries").(int)
+	clientConfig.Timeout = time.Duration(d.Get("client_timeout").(int)) * time.Second
+	clientConfig.CheckRetry = retryablehttp.DefaultRetryPolicy
+	clientConfig.Backoff = retryBackoff
+
 	client, err := api.NewClient(clientConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to configure Vault API: %s", err)
 	}
 
-	// Try an get the token from the config or token helper
-	token, err := providerToken(d)
-	if err != nil {
-		return nil, err
+	if rateLimit := d.Get("rate_limit").(float64); rateLimit > 0 {
+		client.SetLimiter(rateLimit, int(math.Ceil(rateLimit)))
 	}
 
-	username := d.Get("username").(string)
-	if username != "" {
-
-		authLoginPath := fmt.Sprintf("auth/userpass/login/%s", username)
-		authLoginParameters := map[string]interface{}{
-			"password": d.Get("password").(string),
+	// Wrap the transport once the client is created, as creating the client
+	// and configuring TLS expect the default transport.
+	if len(addresses) > 1 {
+		activeAddresses, err := healthyAddresses(client, addresses)
+		if err != nil {
+			return nil, err
+		}
+		// Clones of the client are built from its config, so set the
+		// address there as well.
+		if err := client.SetAddress(activeAddresses[0]); err != nil {
+			return nil, fmt.Errorf("failed to set Vault address: %s", err)
 		}
+		clientConfig.Address = activeAddresses[0]
+		log.Printf("[INFO] Using Vault at %s", activeAddresses[0])
 
-		secret, err := client.Logical().Write(authLoginPath, authLoginParameters)
+		failover, err := newFailoverTransport(clientConfig.HttpClient.Transport, activeAddresses)
 		if err != nil {
 			return nil, err
 		}
-		token = secret.Auth.ClientToken
+		clientConfig.HttpClient.Transport = failover
+	}
+	if maxConcurrentRequests := d.Get("max_concurrent_requests").(int); maxConcurrentRequests > 0 {
+		clientConfig.HttpClient.Transport = newLimitedTransport(clientConfig.HttpClient.Transport, maxConcurrentRequests)
+	}
+
+	// Set the namespace before logging in so that auth methods mounted in
+	// the namespace are used.
+	if namespace := d.Get("namespace").(string); namespace != "" {
+		client.SetNamespace(namespace)
+	}
+
+	// Try an get the token from the config or token helper
+	token, err := providerToken(d, client)
+	if err != nil {
+		return nil, err
+	}
+
+	loginToken, err := providerLoginToken(client, d)
+	if err != nil {
+		return nil, err
+	}
+	if loginToken != "" {
+		token = loginToken
 	}
 
 	if token != "" {
@@ -121,15 +496,20 @@ func providerConfigure(d *schema.ResourceData) (interface{}, error) {
 		return nil, errors.New("no vault token found")
 	}
 
-	tokenName := "terraform"
+	meta := newProviderMeta(client)
+	meta.userBasePath = d.Get("user_base_path").(string)
+	meta.deletionMode = d.Get("deletion_mode").(string)
+	meta.redactLogs = d.Get("redact_logs").(bool)
+	if !d.Get("disable_mount_cache").(bool) {
+		meta.mountCache = newMountCache()
+	}
 
-	renewable := false
-	childTokenLease, err := client.Auth().Token().Create(&api.TokenCreateRequest{
-		DisplayName:    tokenName,
-		TTL:            fmt.Sprintf("%ds", d.Get("max_lease_ttl_seconds").(int)),
-		ExplicitMaxTTL: fmt.Sprintf("%ds", d.Get("max_lease_ttl_seconds").(int)),
-		Renewable:      &renewable,
-	})
+	if d.Get("skip_child_token").(bool) {
+		log.Printf("[INFO] Using the provided Vault token without creating a child token")
+		return meta, nil
+	}
+
+	childTokenLease, err := createChildToken(d, client)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create limited child token: %s", err)
 	}
@@ -141,6 +521,96 @@ func providerConfigure(d *schema.ResourceData) (interface{}, error) {
 
 	// Set tht token to the generated child token
 	client.SetToken(childToken)
+	meta.childTokenAccessor = childTokenLease.Auth.Accessor
+
+	childTokens.Lock()
+	childTokens.clients = append(childTokens.clients, client)
+	childTokens.Unlock()
+
+	if childTokenLease.Auth.Renewable {
+		if err := renewChildToken(client, childTokenLease); err != nil {
+			return nil, fmt.Errorf("failed to start renewing child token: %s", err)
+		}
+	}
+
+	return meta, nil
+}
+
+func createChildToken(d *schema.ResourceData, client *api.Client) (*api.Secret, error) {
+	ttl := fmt.Sprintf("%ds", d.Get("max_lease_ttl_seconds").(int))
+	renewable := d.Get("child_token_renewable").(bool)
+
+	request := &api.TokenCreateRequest{
+		DisplayName: d.Get("child_token_display_name").(string),
+		TTL:         ttl,
+		Renewable:   &renewable,
+	}
+	// A renewable token is kept alive for as long as the provider runs, so
+	// only cap the lifetime of non-renewable tokens.
+	if !renewable {
+		request.ExplicitMaxTTL = ttl
+	}
+	for _, policy := range d.Get("child_token_policies").([]interface{}) {
+		request.Policies = append(request.Policies, policy.(string))
+	}
 
-	return client, nil
+	if d.Get("child_token_orphan").(bool) {
+		return client.Auth().Token().CreateOrphan(request)
+	}
+	return client.Auth().Token().Create(request)
+}
+
+// renewChildToken keeps a renewable child token alive in the background for
+// as long as the provider process runs.
+func renewChildToken(client *api.Client, childTokenLease *api.Secret) error {
+	watcher, err := client.NewLifetimeWatcher(&api.LifetimeWatcherInput{
+		Secret: childTokenLease,
+	})
+	if err != nil {
+		return err
+	}
+
+	childTokens.Lock()
+	childTokens.watchers = append(childTokens.watchers, watcher)
+	childTokens.Unlock()
+
+	go watcher.Start()
+	go func() {
+		for {
+			select {
+			case err := <-watcher.DoneCh():
+				if err != nil {
+					log.Printf("[WARN] Stopped renewing Vault child token: %s", err)
+				} else {
+					log.Printf("[INFO] Stopped renewing Vault child token")
+				}
+				return
+			case renewal := <-watcher.RenewCh():
+				log.Printf("[DEBUG] Renewed Vault child token at %s", renewal.RenewedAt)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// RevokeChildTokens revokes the child tokens created by the provider. It is
+// best-effort: a token that cannot be revoked only logs a warning and expires
+// at the end of its TTL.
+func RevokeChildTokens() {
+	childTokens.Lock()
+	defer childTokens.Unlock()
+
+	for _, watcher := range childTokens.watchers {
+		watcher.Stop()
+	}
+	childTokens.watchers = nil
+
+	for _, client := range childTokens.clients {
+		log.Printf("[DEBUG] Revoking Vault child token")
+		if err := client.Auth().Token().RevokeSelf(""); err != nil {
+			log.Printf("[WARN] Failed to revoke Vault child token: %s", err)
+		}
+	}
+	childTokens.clients = nil
 }
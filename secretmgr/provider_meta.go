@@ -0,0 +1,165 @@
+package secretmgr
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/vault/api"
+)
+
+// ProviderMeta is the meta shared by all resources of a configured provider:
+// the Vault client along with the provider settings the resources need.
+type ProviderMeta struct {
+	client *api.Client
+
+	// namespace is the Vault namespace client sends its requests to.
+	namespace string
+
+	// defaultNamespace is the namespace of the provider, the one resources
+	// whose ID has no namespace live in. It is kept by withNamespace.
+	defaultNamespace string
+
+	// userBasePath is the base_path of secretmgr_user resources that do not
+	// set one.
+	userBasePath string
+
+	// deletionMode is the deletion_mode of resources that do not set one.
+	deletionMode string
+
+	// redactLogs hides the values of secrets from the provider logs.
+	redactLogs bool
+
+	// childTokenAccessor is the accessor of the child token created by the
+	// provider, if any.
+	childTokenAccessor string
+
+	// mountCache is nil when the provider disabled mount caching. It is
+	// shared with the metas derived by withNamespace.
+	mountCache *mountCache
+
+	// namespaced memoizes the metas derived by withNamespace.
+	namespaced *sync.Map
+}
+
+func newProviderMeta(client *api.Client) *ProviderMeta {
+	namespace := clientNamespace(client)
+
+	return &ProviderMeta{
+		client:           client,
+		namespace:        namespace,
+		defaultNamespace: namespace,
+		namespaced:       &sync.Map{},
+	}
+}
+
+// withNamespace returns a meta sending its requests to namespace, or m itself
+// when it already does.
+func (m *ProviderMeta) withNamespace(namespace string) (*ProviderMeta, error) {
+	if namespace == m.namespace {
+		return m, nil
+	}
+
+	if nsMeta, ok := m.namespaced.Load(namespace); ok {
+		return nsMeta.(*ProviderMeta), nil
+	}
+
+	nsClient, err := m.client.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("error cloning Vault client: %s", err)
+	}
+	nsClient.SetToken(m.client.Token())
+	nsClient.SetNamespace(namespace)
+
+	nsMeta := *m
+	nsMeta.client = nsClient
+	nsMeta.namespace = namespace
+	actual, _ := m.namespaced.LoadOrStore(namespace, &nsMeta)
+
+	return actual.(*ProviderMeta), nil
+}
+
+// resourceCreateMeta returns the meta a new resource is created with, in the
+// namespace set on the resource or else in the provider namespace.
+func resourceCreateMeta(d *schema.ResourceData, meta interface{}) (*ProviderMeta, error) {
+	providerMeta := meta.(*ProviderMeta)
+
+	namespace := d.Get("namespace").(string)
+	if namespace == "" {
+		namespace = providerMeta.namespace
+	}
+
+	return providerMeta.withNamespace(namespace)
+}
+
+// resourceID returns the ID of the resource at path in the namespace of m.
+func (m *ProviderMeta) resourceID(path string) string {
+	return resourceID(m.namespace, m.defaultNamespace, path)
+}
+
+// resourceIDMeta returns the meta of an existing resource, in the namespace
+// recorded in its ID, along with the path of the resource. IDs without
+// namespace were written before namespaces were recorded: they are in the
+// namespace recorded in state, or else in the provider namespace, and are
+// rewritten with their namespace.
+func resourceIDMeta(d *schema.ResourceData, meta interface{}) (*ProviderMeta, string, error) {
+	stateNamespace, _ := d.Get("namespace").(string)
+
+	idMeta, path, ok, err := parseResourceIDMeta(d.Id(), stateNamespace, meta.(*ProviderMeta))
+	if err != nil {
+		return nil, "", err
+	}
+	if !ok {
+		d.SetId(idMeta.resourceID(path))
+	}
+
+	return idMeta, path, nil
+}
+
+// resourceDiffIDMeta is resourceIDMeta for the CustomizeDiff of an existing
+// resource.
+func resourceDiffIDMeta(d *schema.ResourceDiff, meta interface{}) (*ProviderMeta, string, error) {
+	stateNamespace, _ := d.Get("namespace").(string)
+
+	idMeta, path, _, err := parseResourceIDMeta(d.Id(), stateNamespace, meta.(*ProviderMeta))
+	return idMeta, path, err
+}
+
+// parseResourceIDMeta returns the meta and path of the resource ID id. ok is
+// false for an ID without namespace, which is resolved against stateNamespace
+// or else the provider namespace.
+func parseResourceIDMeta(id, stateNamespace string, providerMeta *ProviderMeta) (*ProviderMeta, string, bool, error) {
+	namespace, path, ok := parseResourceID(id)
+	if !ok {
+		namespace = providerMeta.defaultNamespace
+		if stateNamespace != "" {
+			namespace = stateNamespace
+		}
+	}
+
+	idMeta, err := providerMeta.withNamespace(namespace)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	return idMeta, path, ok, nil
+}
+
+// logSecret logs the secret read at path, only listing its keys when the
+// provider redacts its logs.
+func (m *ProviderMeta) logSecret(path string, secret *api.Secret) {
+	if !m.redactLogs {
+		log.Printf("[DEBUG] secret %s: %#v", path, secret)
+		return
+	}
+
+	keys := make([]string, 0, len(secret.Data))
+	for k := range secret.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	log.Printf("[DEBUG] secret %s: keys %v, values redacted", path, keys)
+}
@@ -0,0 +1,147 @@
+package secretmgr
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/hashicorp/vault/api"
+)
+
+func TestWithNamespace(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+
+	same, err := meta.withNamespace("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same != meta {
+		t.Fatal("expected withNamespace of the current namespace to return the meta itself")
+	}
+
+	team, err := meta.withNamespace("team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if team.namespace != "team" || clientNamespace(team.client) != "team" {
+		t.Fatalf("expected a meta in namespace team, got %q", clientNamespace(team.client))
+	}
+	if clientNamespace(meta.client) != "" {
+		t.Fatalf("expected the provider client to keep its namespace, got %q", clientNamespace(meta.client))
+	}
+	if team.client.Token() != meta.client.Token() {
+		t.Fatal("expected the namespaced client to use the provider token")
+	}
+	if team.mountCache != meta.mountCache {
+		t.Fatal("expected the namespaced meta to share the mount cache")
+	}
+	if team.defaultNamespace != "" {
+		t.Fatalf("expected the namespaced meta to keep the provider namespace, got %q", team.defaultNamespace)
+	}
+
+	again, err := meta.withNamespace("team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != team {
+		t.Fatal("expected withNamespace to be memoized")
+	}
+
+	if _, err := versionedSecret(latestSecretVersion, "secret/a", team); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.namespaces[len(f.namespaces)-1]; got != "team" {
+		t.Fatalf("expected the request to be sent to namespace team, got %q", got)
+	}
+}
+
+func TestResourceIDMeta(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+
+	client := testClient(t, f)
+	client.SetNamespace("team")
+	teamMeta := newProviderMeta(client)
+
+	cases := []struct {
+		meta           *ProviderMeta
+		id             string
+		stateNamespace string
+		namespace      string
+		newID          string
+	}{
+		{meta, "secret/a", "", "", "secret/a"},
+		{meta, "team//secret/a", "", "team", "team//secret/a"},
+		{meta, "team//secret/a", "other", "team", "team//secret/a"},
+		{meta, "secret/a", "team", "team", "team//secret/a"},
+		{teamMeta, "team//secret/a", "", "team", "team//secret/a"},
+		{teamMeta, "//secret/a", "", "", "//secret/a"},
+		{teamMeta, "other//secret/a", "", "other", "other//secret/a"},
+		{teamMeta, "secret/a", "", "team", "team//secret/a"},
+		{teamMeta, "secret/a", "other", "other", "other//secret/a"},
+	}
+	for _, c := range cases {
+		d := resourceKvSecret().Data(&terraform.InstanceState{
+			ID:         c.id,
+			Attributes: map[string]string{"namespace": c.stateNamespace},
+		})
+
+		idMeta, path, err := resourceIDMeta(d, c.meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if idMeta.namespace != c.namespace || path != "secret/a" {
+			t.Errorf("resourceIDMeta(%q) = %q, %q, expected %q, %q", c.id, idMeta.namespace, path, c.namespace, "secret/a")
+		}
+		if d.Id() != c.newID {
+			t.Errorf("resourceIDMeta(%q) set the ID %q, expected %q", c.id, d.Id(), c.newID)
+		}
+	}
+}
+
+func TestResourceIDMetaProviderNamespaceChange(t *testing.T) {
+	f := newFakeKV(t)
+
+	client := testClient(t, f)
+	client.SetNamespace("team")
+	teamMeta := newProviderMeta(client)
+
+	d := resourceKvSecret().Data(nil)
+	d.SetId(teamMeta.resourceID("secret/a"))
+
+	// The next run has another provider namespace, e.g. from VAULT_NAMESPACE.
+	client = testClient(t, f)
+	client.SetNamespace("other")
+	idMeta, _, err := resourceIDMeta(d, newProviderMeta(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idMeta.namespace != "team" {
+		t.Fatalf("expected the resource to stay in namespace team, got %q", idMeta.namespace)
+	}
+}
+
+func TestLogSecret(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	secret := &api.Secret{Data: map[string]interface{}{"password": "hunter2"}}
+
+	meta := &ProviderMeta{redactLogs: true}
+	meta.logSecret("secret/a", secret)
+	if strings.Contains(buf.String(), "hunter2") || !strings.Contains(buf.String(), "password") {
+		t.Fatalf("expected only the keys of the secret to be logged, got %q", buf.String())
+	}
+
+	buf.Reset()
+	meta.redactLogs = false
+	meta.logSecret("secret/a", secret)
+	if !strings.Contains(buf.String(), "hunter2") {
+		t.Fatalf("expected the secret to be logged, got %q", buf.String())
+	}
+}
@@ -0,0 +1,244 @@
+package secretmgr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func TestProvider(t *testing.T) {
+	if err := Provider().InternalValidate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// validateProviderConfig returns the errors of Provider().Validate on raw.
+func validateProviderConfig(t *testing.T, raw map[string]interface{}) []string {
+	t.Helper()
+
+	diags := Provider().Validate(terraform.NewResourceConfigRaw(raw))
+
+	var errs []string
+	for _, d := range diags {
+		errs = append(errs, d.Summary+": "+d.Detail)
+	}
+	return errs
+}
+
+func TestProviderAuthLoginJwtFile(t *testing.T) {
+	os.Unsetenv("TERRAFORM_VAULT_AUTH_JWT")
+
+	errs := validateProviderConfig(t, map[string]interface{}{
+		"token": "token",
+		"auth_login_jwt": []interface{}{
+			map[string]interface{}{
+				"role":     "ci",
+				"jwt_file": "/var/run/secrets/jwt",
+			},
+		},
+	})
+	for _, err := range errs {
+		if strings.Contains(err, "ConflictsWith") {
+			t.Fatalf("unexpected conflict validating jwt_file: %s", err)
+		}
+	}
+}
+
+func TestProviderAddressesWithVaultAddr(t *testing.T) {
+	defer os.Setenv("VAULT_ADDR", os.Getenv("VAULT_ADDR"))
+	os.Setenv("VAULT_ADDR", "https://vault.example.com:8200")
+
+	errs := validateProviderConfig(t, map[string]interface{}{
+		"token": "token",
+		"addresses": []interface{}{
+			"https://vault-1.example.com:8200",
+			"https://vault-2.example.com:8200",
+		},
+	})
+	for _, err := range errs {
+		if strings.Contains(err, "ConflictsWith") {
+			t.Fatalf("unexpected conflict validating addresses: %s", err)
+		}
+	}
+}
+
+// configureTestProvider configures the provider with raw, which should skip
+// the creation of a child token.
+func configureTestProvider(t *testing.T, raw map[string]interface{}) (*ProviderMeta, error) {
+	t.Helper()
+
+	d := schema.TestResourceDataRaw(t, Provider().Schema, raw)
+	meta, err := providerConfigure(d)
+	if err != nil {
+		return nil, err
+	}
+	return meta.(*ProviderMeta), nil
+}
+
+func TestProviderMaxRetries(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1)%2 == 1 {
+			writeTestJSON(w, 503, map[string]interface{}{"errors": []string{"Vault is sealed"}})
+			return
+		}
+		writeTestJSON(w, 200, map[string]interface{}{"data": map[string]interface{}{"value": "x"}})
+	}))
+	defer srv.Close()
+
+	for _, maxRetries := range []int{0, 1} {
+		atomic.StoreInt32(&requests, 0)
+
+		meta, err := configureTestProvider(t, map[string]interface{}{
+			"address":          srv.URL,
+			"token":            "test-token",
+			"skip_child_token": true,
+			"max_retries":      maxRetries,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = meta.client.Logical().Read("kv1/a")
+		if maxRetries == 0 && err == nil {
+			t.Fatal("expected the 503 response not to be retried")
+		}
+		if maxRetries == 1 && err != nil {
+			t.Fatalf("expected the 503 response to be retried, got %s", err)
+		}
+		if n := atomic.LoadInt32(&requests); n != int32(maxRetries+1) {
+			t.Fatalf("expected %d requests with max_retries = %d, got %d", maxRetries+1, maxRetries, n)
+		}
+	}
+}
+
+func TestProviderClientTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	meta, err := configureTestProvider(t, map[string]interface{}{
+		"address":          srv.URL,
+		"token":            "test-token",
+		"skip_child_token": true,
+		"max_retries":      0,
+		"client_timeout":   1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err := meta.client.Logical().Read("kv1/a"); err == nil {
+		t.Fatal("expected the request to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("expected the request to time out after 1s, took %s", elapsed)
+	}
+}
+
+func TestProviderMaxConcurrentRequestsWithRedirects(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/standby/a" {
+			http.Redirect(w, r, "/v1/kv1/a", http.StatusTemporaryRedirect)
+			return
+		}
+		writeTestJSON(w, 200, map[string]interface{}{"data": map[string]interface{}{"value": "x"}})
+	}))
+	defer srv.Close()
+
+	meta, err := configureTestProvider(t, map[string]interface{}{
+		"address":                 srv.URL,
+		"token":                   "test-token",
+		"skip_child_token":        true,
+		"max_concurrent_requests": 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Redirects followed by the Vault client must not keep their slot.
+	errs := make(chan error, 1)
+	go func() {
+		for i := 0; i < 3; i++ {
+			if _, err := meta.client.Logical().Read("standby/a"); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- nil
+	}()
+
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("requests blocked after following redirects")
+	}
+}
+
+func TestProviderAddressesClones(t *testing.T) {
+	// A sealed node still answers, so requests sent to it are not failed over.
+	sealed := httptest.NewServer(healthHandler(true, true, false, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, 503, map[string]interface{}{"errors": []string{"Vault is sealed"}})
+	})))
+	defer sealed.Close()
+
+	active := httptest.NewServer(healthHandler(true, false, false, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/sys/wrapping/lookup":
+			writeTestJSON(w, 200, map[string]interface{}{"data": map[string]interface{}{}})
+		case "/v1/sys/wrapping/unwrap":
+			writeTestJSON(w, 200, map[string]interface{}{"data": map[string]interface{}{"secret_id": "unwrapped"}})
+		default:
+			writeTestJSON(w, 200, map[string]interface{}{"data": map[string]interface{}{"value": "x"}})
+		}
+	})))
+	defer active.Close()
+
+	meta, err := configureTestProvider(t, map[string]interface{}{
+		"addresses":        []interface{}{sealed.URL, active.URL},
+		"token":            "test-token",
+		"skip_child_token": true,
+		"max_retries":      0,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nsMeta, err := meta.withNamespace("team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*ProviderMeta{meta, nsMeta} {
+		if m.client.Address() != active.URL {
+			t.Errorf("expected the client of namespace %q to use %s, got %s", m.namespace, active.URL, m.client.Address())
+		}
+		if _, err := m.client.Logical().Read("kv1/a"); err != nil {
+			t.Errorf("error reading in namespace %q: %s", m.namespace, err)
+		}
+	}
+
+	secret, err := unwrapSecret(meta.client, "wrapping-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Data["secret_id"] != "unwrapped" {
+		t.Fatalf("expected the unwrapped secret, got %v", secret.Data)
+	}
+}
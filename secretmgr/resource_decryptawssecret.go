@@ -4,6 +4,9 @@ import (
 	// "encoding/json"
 
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -11,7 +14,7 @@ import (
 	"encoding/base64"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
-	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"golang.org/x/crypto/openpgp"
 )
 
@@ -20,12 +23,13 @@ func resourceDecryptAwsSecret() *schema.Resource {
 		SchemaVersion: 1,
 
 		Create: decryptAwsSecretResourceWrite,
-		// Update: gpgResourceUpdate,
+		Update: decryptAwsSecretResourceUpdate,
 		Delete: decryptAwsSecretResourceDelete,
 		Read:   decryptAwsSecretResourceRead,
-		// Importer: &schema.ResourceImporter{
-		// 	State: schema.ImportStatePassthrough,
-		// },
+		Importer: &schema.ResourceImporter{
+			State: decryptAwsSecretResourceImport,
+		},
+		CustomizeDiff: decryptAwsSecretCustomizeDiff,
 		// MigrateState: resourceUserMigrateState,
 
 		Schema: map[string]*schema.Schema{
@@ -39,9 +43,8 @@ func resourceDecryptAwsSecret() *schema.Resource {
 			"encrypted_secret": {
 				Type:        schema.TypeString,
 				Required:    true,
-				ForceNew:    true,
 				Sensitive:   true,
-				Description: "encrypted_secret",
+				Description: "encrypted_secret. The secret is decrypted and written again when it changes, and on the first apply after an import.",
 			},
 			"path": {
 				Type:        schema.TypeString,
@@ -52,16 +55,33 @@ func resourceDecryptAwsSecret() *schema.Resource {
 			"gpg_private_path": {
 				Type:        schema.TypeString,
 				Required:    true,
+				Description: "gpg_private_path. The secret is decrypted and written again when it changes.",
+			},
+			"secret_key_hash": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "SHA-256 of the stored secret key. The secret is replaced when the key was changed outside Terraform.",
+			},
+			"deletion_mode": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringInSlice(deletionModes, false),
+				Description:  "How KV v2 secrets are deleted: soft, destroy or purge. Defaults to the provider deletion_mode.",
+			},
+			"namespace": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
 				ForceNew:    true,
-				Description: "gpg_private_path",
+				Description: "Vault namespace of the secret. Defaults to the provider namespace.",
 			},
 		},
 	}
 }
 
-func decryptWithGpg(gpg_private_path string, encrypted_secret string, client *api.Client) (string, error) {
+func decryptWithGpg(gpg_private_path string, encrypted_secret string, meta *ProviderMeta) (string, error) {
 
-	secret, err := versionedSecret(0, gpg_private_path, client)
+	secret, err := versionedSecret(0, gpg_private_path, meta)
 	if err != nil {
 		return "", err
 	}
@@ -101,15 +121,33 @@ func decryptWithGpg(gpg_private_path string, encrypted_secret string, client *ap
 }
 
 func decryptAwsSecretResourceWrite(d *schema.ResourceData, meta interface{}) error {
-	client := meta.(*api.Client)
+	providerMeta, err := resourceCreateMeta(d, meta)
+	if err != nil {
+		return err
+	}
+	namespace := providerMeta.namespace
 
-	encrypted_secret := d.Get("encrypted_secret").(string)
 	path := d.Get("path").(string)
 	originalPath := path
+
+	if err := writeDecryptedAwsSecret(d, path, providerMeta); err != nil {
+		return err
+	}
+
+	d.Set("namespace", namespace)
+	d.SetId(providerMeta.resourceID(originalPath))
+
+	return decryptAwsSecretResourceRead(d, meta)
+}
+
+// writeDecryptedAwsSecret decrypts the encrypted_secret of d and writes it to
+// path along with the access_key.
+func writeDecryptedAwsSecret(d *schema.ResourceData, path string, meta *ProviderMeta) error {
+	encrypted_secret := d.Get("encrypted_secret").(string)
 	gpg_private_path := d.Get("gpg_private_path").(string)
 	access_key := d.Get("access_key").(string)
 
-	decryptSecretKey, err := decryptWithGpg(gpg_private_path, encrypted_secret, client)
+	decryptSecretKey, err := decryptWithGpg(gpg_private_path, encrypted_secret, meta)
 	if err != nil {
 		return fmt.Errorf("error decrypting aws secret key: %s", err)
 	}
@@ -119,24 +157,25 @@ func decryptAwsSecretResourceWrite(d *schema.ResourceData, meta interface{}) err
 		"AWS_SECRET_KEY": decryptSecretKey,
 	}
 
-	err = addVersionedSecret(path, &payLoad, client)
+	err = addVersionedSecret(path, &payLoad, meta)
 	if err != nil {
 		return fmt.Errorf("error add secret : %s", err)
 	}
 
-	d.SetId(originalPath)
+	d.Set("secret_key_hash", hashSecretKey(decryptSecretKey))
 
-	return decryptAwsSecretResourceRead(d, meta)
+	return nil
 }
 
 func decryptAwsSecretResourceRead(d *schema.ResourceData, meta interface{}) error {
 
-	path := d.Id()
-
-	client := meta.(*api.Client)
+	providerMeta, path, err := resourceIDMeta(d, meta)
+	if err != nil {
+		return err
+	}
 
 	log.Printf("[DEBUG] Reading %s from Vault", path)
-	secret, err := versionedSecret(latestSecretVersion, path, client)
+	secret, err := versionedSecret(latestSecretVersion, path, providerMeta)
 
 	if err != nil {
 		return fmt.Errorf("error reading from Vault: %s", err)
@@ -147,29 +186,120 @@ func decryptAwsSecretResourceRead(d *schema.ResourceData, meta interface{}) erro
 		return nil
 	}
 
-	log.Printf("[DEBUG] secret: %#v", secret)
+	// A secret key changed outside Terraform keeps the hash of the secret
+	// key Terraform wrote, decryptAwsSecretCustomizeDiff then plans a
+	// replacement.
+	secretKey, _ := secret.Data["AWS_SECRET_KEY"].(string)
+	secretKeyHash := hashSecretKey(secretKey)
+	if previous := d.Get("secret_key_hash").(string); previous != "" && previous != secretKeyHash {
+		log.Printf("[WARN] secret key (%s) was changed outside Terraform", path)
+	} else {
+		d.Set("secret_key_hash", secretKeyHash)
+	}
+
+	accessKey, _ := secret.Data["AWS_ACCESS_KEY"].(string)
+
+	d.Set("access_key", accessKey)
+	d.Set("namespace", providerMeta.namespace)
 
 	return nil
 }
 
-func decryptAwsSecretResourceDelete(d *schema.ResourceData, meta interface{}) error {
+// decryptAwsSecretCustomizeDiff replaces a secret whose secret key was
+// changed outside Terraform, whose hash is not the one in state anymore.
+func decryptAwsSecretCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	previous := d.Get("secret_key_hash").(string)
+	if d.Id() == "" || previous == "" {
+		return nil
+	}
+
+	providerMeta, path, err := resourceDiffIDMeta(d, meta)
+	if err != nil {
+		return err
+	}
+
+	secret, err := versionedSecret(latestSecretVersion, path, providerMeta)
+	if err != nil {
+		return fmt.Errorf("error reading from Vault: %s", err)
+	}
+	if secret == nil {
+		return nil
+	}
 
-	path := d.Id()
+	secretKey, _ := secret.Data["AWS_SECRET_KEY"].(string)
+	if hashSecretKey(secretKey) != previous {
+		log.Printf("[INFO] secret key (%s) was changed outside Terraform, replacing it", path)
+		if err := d.SetNewComputed("secret_key_hash"); err != nil {
+			return err
+		}
+		return d.ForceNew("secret_key_hash")
+	}
+
+	return nil
+}
 
-	client := meta.(*api.Client)
+// hashSecretKey returns the hex SHA-256 of secretKey, kept in state instead of
+// the secret key itself.
+func hashSecretKey(secretKey string) string {
+	sum := sha256.Sum256([]byte(secretKey))
+	return hex.EncodeToString(sum[:])
+}
 
-	mountPath, v2, err := isKVv2(path, client)
+// decryptAwsSecretResourceImport fills in what the stored secret holds. The
+// encrypted_secret and gpg_private_path it was decrypted with are not stored,
+// they are left empty and set by the update of the first apply.
+func decryptAwsSecretResourceImport(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	providerMeta, path, err := resourceIDMeta(d, meta)
 	if err != nil {
-		return fmt.Errorf("error determining if it's a v2 path: %s", err)
+		return nil, err
 	}
 
-	if v2 {
-		path = addPrefixToVKVPath(path, mountPath, "metadata")
+	secret, err := versionedSecret(latestSecretVersion, path, providerMeta)
+	if err != nil {
+		return nil, fmt.Errorf("error reading from Vault: %s", err)
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("no secret found at %q", path)
+	}
+
+	accessKey, ok := secret.Data["AWS_ACCESS_KEY"].(string)
+	if !ok {
+		return nil, fmt.Errorf("no AWS_ACCESS_KEY found in %q", path)
+	}
+
+	d.Set("access_key", accessKey)
+	d.Set("path", path)
+	d.Set("namespace", providerMeta.namespace)
+
+	return []*schema.ResourceData{d}, nil
+}
+
+func decryptAwsSecretResourceUpdate(d *schema.ResourceData, meta interface{}) error {
+	if d.HasChanges("encrypted_secret", "gpg_private_path") {
+		providerMeta, path, err := resourceIDMeta(d, meta)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("[DEBUG] Writing %s decrypted again to Vault", path)
+		if err := writeDecryptedAwsSecret(d, path, providerMeta); err != nil {
+			return err
+		}
+	}
+
+	return decryptAwsSecretResourceRead(d, meta)
+}
+
+func decryptAwsSecretResourceDelete(d *schema.ResourceData, meta interface{}) error {
+
+	providerMeta, path, err := resourceIDMeta(d, meta)
+	if err != nil {
+		return err
 	}
 
 	log.Printf("[DEBUG] Delete %s from Vault", path)
 
-	err = deleteSecretCascade(path, meta)
+	err = deleteSecretCascade(path, resourceDeletionMode(d, providerMeta), providerMeta)
 	if err != nil {
 		return fmt.Errorf("error deleting path: %s", err)
 	}
@@ -0,0 +1,172 @@
+package secretmgr
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"golang.org/x/crypto/openpgp"
+)
+
+// createTestGpgKey creates a GPG key at secret/gpg/<name> with secretmgr_gpg.
+func createTestGpgKey(t *testing.T, name string, meta *ProviderMeta) *terraform.InstanceState {
+	t.Helper()
+
+	state, err := applyResource(t, resourceGpg(), nil, map[string]interface{}{
+		"name":        name,
+		"path":        "secret/gpg/" + name,
+		"create_date": "v1",
+	}, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+// encryptTestSecret encrypts secret for the GPG key at secret/gpg/<name> and
+// returns it base64-encoded, as encrypted_secret expects it.
+func encryptTestSecret(t *testing.T, name, secret string, meta *ProviderMeta) string {
+	t.Helper()
+
+	key, err := readGpgKey("secret/gpg/"+name+"/public", meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w, err := openpgp.Encrypt(&buf, []*openpgp.Entity{key}, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(secret)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func awsSecretConfig(encryptedSecret string) map[string]interface{} {
+	return map[string]interface{}{
+		"access_key":       "AKIAEXAMPLE",
+		"encrypted_secret": encryptedSecret,
+		"path":             "secret/aws/ci",
+		"gpg_private_path": "secret/gpg/ci/private",
+	}
+}
+
+func TestDecryptAwsSecretResourceUpdate(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+	r := resourceDecryptAwsSecret()
+	createTestGpgKey(t, "ci", meta)
+
+	state, err := applyResource(t, r, nil, awsSecretConfig(encryptTestSecret(t, "ci", "secret-key", meta)), meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.v2["aws/ci"]; len(got) != 1 || got[0]["AWS_SECRET_KEY"] != "secret-key" {
+		t.Fatalf("expected the decrypted secret key to be written, got %v", got)
+	}
+
+	// A rotated secret key is written in place.
+	state, err = applyResource(t, r, state, awsSecretConfig(encryptTestSecret(t, "ci", "rotated-key", meta)), meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.v2["aws/ci"]; len(got) != 2 || got[1]["AWS_SECRET_KEY"] != "rotated-key" {
+		t.Fatalf("expected the rotated secret key to be written, got %v", got)
+	}
+	if state.ID != "secret/aws/ci" || state.Attributes["secret_key_hash"] != hashSecretKey("rotated-key") {
+		t.Fatalf("unexpected state after the rotation: %v", state)
+	}
+}
+
+func TestDecryptAwsSecretResourceApplyAfterImport(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+	r := resourceDecryptAwsSecret()
+	createTestGpgKey(t, "ci", meta)
+
+	payLoad := map[string]interface{}{
+		"AWS_ACCESS_KEY": "AKIAEXAMPLE",
+		"AWS_SECRET_KEY": "secret-key",
+	}
+	if err := addVersionedSecret("secret/aws/ci", &payLoad, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	state := importState(t, r, "secret/aws/ci", meta)
+	encrypted := encryptTestSecret(t, "ci", "secret-key", meta)
+
+	// The first apply fills in the arguments the import could not.
+	state, err := applyResource(t, r, state, awsSecretConfig(encrypted), meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Attributes["encrypted_secret"] != encrypted || state.Attributes["gpg_private_path"] != "secret/gpg/ci/private" {
+		t.Fatalf("expected the configured arguments to be stored, got %v", state.Attributes)
+	}
+
+	// Later changes are applied.
+	state, err = applyResource(t, r, state, awsSecretConfig(encryptTestSecret(t, "ci", "rotated-key", meta)), meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.v2["aws/ci"]; got[len(got)-1]["AWS_SECRET_KEY"] != "rotated-key" {
+		t.Fatalf("expected the rotated secret key to be written, got %v", got)
+	}
+}
+
+func TestDecryptAwsSecretResourceDrift(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+	r := resourceDecryptAwsSecret()
+	createTestGpgKey(t, "ci", meta)
+
+	config := awsSecretConfig(encryptTestSecret(t, "ci", "secret-key", meta))
+	state, err := applyResource(t, r, nil, config, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := r.Diff(context.Background(), refreshResource(t, r, state, meta), terraform.NewResourceConfigRaw(config), meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.Empty() {
+		t.Fatalf("expected no changes, got %v", diff)
+	}
+
+	payLoad := map[string]interface{}{
+		"AWS_ACCESS_KEY": "AKIAEXAMPLE",
+		"AWS_SECRET_KEY": "changed-outside",
+	}
+	if err := addVersionedSecret("secret/aws/ci", &payLoad, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	state = refreshResource(t, r, state, meta)
+	if state == nil || state.Attributes["secret_key_hash"] != hashSecretKey("secret-key") {
+		t.Fatalf("expected the resource to stay in state with the hash Terraform wrote, got %v", state)
+	}
+
+	diff, err = r.Diff(context.Background(), state, terraform.NewResourceConfigRaw(config), meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.RequiresNew() {
+		t.Fatalf("expected the changed secret key to be replaced, got %v", diff)
+	}
+
+	if _, err := applyResource(t, r, state, config, meta); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.v2["aws/ci"]; got[len(got)-1]["AWS_SECRET_KEY"] != "secret-key" {
+		t.Fatalf("expected the secret key to be written again, got %v", got)
+	}
+}
@@ -3,16 +3,20 @@ package secretmgr
 import (
 	// "encoding/json"
 
+	"context"
 	"fmt"
 	"log"
 
 	PATH "path"
+	"time"
 
+	"bytes"
 	"encoding/base64"
 
 	"github.com/alokmenghrajani/gpgeez"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
-	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"golang.org/x/crypto/openpgp"
 )
 
 func resourceGpg() *schema.Resource {
@@ -20,12 +24,13 @@ func resourceGpg() *schema.Resource {
 		SchemaVersion: 1,
 
 		Create: gpgResourceWrite,
-		// Update: gpgResourceUpdate,
+		Update: gpgResourceUpdate,
 		Delete: gpgResourceDelete,
 		Read:   gpgResourceRead,
-		// Importer: &schema.ResourceImporter{
-		// 	State: schema.ImportStatePassthrough,
-		// },
+		Importer: &schema.ResourceImporter{
+			State: gpgResourceImport,
+		},
+		CustomizeDiff: gpgCustomizeDiff,
 		// MigrateState: resourceUserMigrateState,
 
 		Schema: map[string]*schema.Schema{
@@ -42,10 +47,11 @@ func resourceGpg() *schema.Resource {
 				Description: "path",
 			},
 			"create_date": {
-				Type:        schema.TypeString,
-				Required:    true,
-				ForceNew:    true,
-				Description: "date",
+				Type:             schema.TypeString,
+				Required:         true,
+				ForceNew:         true,
+				DiffSuppressFunc: suppressSameDateDiff,
+				Description:      "date. Imported keys get the day the key was created as YYYY-MM-DD, the same day written as RFC 3339, YYYY/MM/DD or YYYYMMDD is not a change.",
 			},
 			"privatekey_path": {
 				Type:        schema.TypeString,
@@ -59,12 +65,34 @@ func resourceGpg() *schema.Resource {
 				Computed:    true,
 				Description: "Path of the public key.",
 			},
+			"fingerprint": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Fingerprint of the public key. The key is replaced when it was replaced outside Terraform.",
+			},
+			"deletion_mode": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringInSlice(deletionModes, false),
+				Description:  "How KV v2 secrets are deleted: soft, destroy or purge. Defaults to the provider deletion_mode.",
+			},
+			"namespace": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "Vault namespace of the secret. Defaults to the provider namespace.",
+			},
 		},
 	}
 }
 
 func gpgResourceWrite(d *schema.ResourceData, meta interface{}) error {
-	client := meta.(*api.Client)
+	providerMeta, err := resourceCreateMeta(d, meta)
+	if err != nil {
+		return err
+	}
+	namespace := providerMeta.namespace
 
 	name := d.Get("name").(string)
 	path := d.Get("path").(string)
@@ -84,7 +112,7 @@ func gpgResourceWrite(d *schema.ResourceData, meta interface{}) error {
 		"KEY": base64.StdEncoding.EncodeToString(key.Keyring()),
 	}
 
-	err = addVersionedSecret(pubPath, &payLoad, client)
+	err = addVersionedSecret(pubPath, &payLoad, providerMeta)
 	if err != nil {
 		return fmt.Errorf("error add secret : %s", err)
 	}
@@ -93,7 +121,7 @@ func gpgResourceWrite(d *schema.ResourceData, meta interface{}) error {
 		"KEY": base64.StdEncoding.EncodeToString(key.Secring(&config)),
 	}
 
-	err = addVersionedSecret(privPath, &payLoad, client)
+	err = addVersionedSecret(privPath, &payLoad, providerMeta)
 	if err != nil {
 		return fmt.Errorf("error add secret : %s", err)
 	}
@@ -101,7 +129,8 @@ func gpgResourceWrite(d *schema.ResourceData, meta interface{}) error {
 	d.Set("privatekey_path", privPath)
 	d.Set("publickey_path", pubPath)
 
-	d.SetId(originalPath)
+	d.Set("namespace", namespace)
+	d.SetId(providerMeta.resourceID(originalPath))
 
 	return gpgResourceRead(d, meta)
 }
@@ -110,43 +139,190 @@ func gpgResourceRead(d *schema.ResourceData, meta interface{}) error {
 
 	publickey_path := d.Get("publickey_path").(string)
 
-	client := meta.(*api.Client)
+	providerMeta, _, err := resourceIDMeta(d, meta)
+	if err != nil {
+		return err
+	}
 
 	log.Printf("[DEBUG] Reading %s from Vault", publickey_path)
-	secret, err := versionedSecret(latestSecretVersion, publickey_path, client)
-
+	key, err := readGpgKey(publickey_path, providerMeta)
 	if err != nil {
-		return fmt.Errorf("error reading from Vault: %s", err)
+		return err
 	}
-	if secret == nil {
+	if key == nil {
 		log.Printf("[WARN] secret (%s) not found, removing from state", publickey_path)
 		d.SetId("")
 		return nil
 	}
 
-	log.Printf("[DEBUG] secret: %#v", secret)
+	// A key replaced outside Terraform keeps the fingerprint of the key
+	// Terraform created, gpgCustomizeDiff then plans a replacement.
+	fingerprint := fmt.Sprintf("%X", key.PrimaryKey.Fingerprint)
+	if previous := d.Get("fingerprint").(string); previous != "" && previous != fingerprint {
+		log.Printf("[WARN] key (%s) was replaced outside Terraform, fingerprint %s instead of %s",
+			publickey_path, fingerprint, previous)
+	} else {
+		d.Set("fingerprint", fingerprint)
+	}
+	d.Set("namespace", providerMeta.namespace)
 
 	return nil
 }
 
-func gpgResourceDelete(d *schema.ResourceData, meta interface{}) error {
+// gpgCustomizeDiff replaces a key that was replaced outside Terraform, whose
+// fingerprint is not the one in state anymore.
+func gpgCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	previous := d.Get("fingerprint").(string)
+	if d.Id() == "" || previous == "" {
+		return nil
+	}
 
-	path := d.Id()
+	providerMeta, _, err := resourceDiffIDMeta(d, meta)
+	if err != nil {
+		return err
+	}
 
-	client := meta.(*api.Client)
+	publickey_path := d.Get("publickey_path").(string)
+	key, err := readGpgKey(publickey_path, providerMeta)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return nil
+	}
+
+	if fingerprint := fmt.Sprintf("%X", key.PrimaryKey.Fingerprint); fingerprint != previous {
+		log.Printf("[INFO] key (%s) was replaced outside Terraform, fingerprint %s instead of %s, replacing it",
+			publickey_path, fingerprint, previous)
+		if err := d.SetNewComputed("fingerprint"); err != nil {
+			return err
+		}
+		return d.ForceNew("fingerprint")
+	}
 
-	mountPath, v2, err := isKVv2(path, client)
+	return nil
+}
+
+// readGpgKey returns the first key of the base64 keyring stored in the KEY of
+// the secret at path, or nil when there is no secret.
+func readGpgKey(path string, meta *ProviderMeta) (*openpgp.Entity, error) {
+	secret, err := versionedSecret(latestSecretVersion, path, meta)
+	if err != nil {
+		return nil, fmt.Errorf("error reading from Vault: %s", err)
+	}
+	if secret == nil {
+		return nil, nil
+	}
+
+	keyString, ok := secret.Data["KEY"].(string)
+	if !ok {
+		return nil, fmt.Errorf("no KEY found in %q", path)
+	}
+	keyByte, err := base64.StdEncoding.DecodeString(keyString)
 	if err != nil {
-		return fmt.Errorf("error determining if it's a v2 path: %s", err)
+		return nil, fmt.Errorf("error decoding KEY of %q: %s", path, err)
 	}
 
-	if v2 {
-		path = addPrefixToVKVPath(path, mountPath, "metadata")
+	entityList, err := openpgp.ReadKeyRing(bytes.NewBuffer(keyByte))
+	if err != nil {
+		return nil, fmt.Errorf("error reading key of %q: %s", path, err)
+	}
+	if len(entityList) == 0 {
+		return nil, fmt.Errorf("no key found in %q", path)
+	}
+
+	return entityList[0], nil
+}
+
+// gpgDateFormats are the formats of create_date recognized as dates by
+// suppressSameDateDiff.
+var gpgDateFormats = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"2006/01/02",
+	"20060102",
+}
+
+// suppressSameDateDiff ignores a change of create_date between two ways of
+// writing the same day, e.g. after an import set it to the key creation date.
+func suppressSameDateDiff(k, old, new string, d *schema.ResourceData) bool {
+	oldDate, ok := parseGpgDate(old)
+	if !ok {
+		return false
+	}
+	newDate, ok := parseGpgDate(new)
+	if !ok {
+		return false
+	}
+	return oldDate == newDate
+}
+
+// parseGpgDate returns the day s is on, as YYYY-MM-DD.
+func parseGpgDate(s string) (string, bool) {
+	for _, format := range gpgDateFormats {
+		if t, err := time.Parse(format, s); err == nil {
+			return t.Format("2006-01-02"), true
+		}
+	}
+	return "", false
+}
+
+func gpgResourceImport(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	providerMeta, path, err := resourceIDMeta(d, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	pubPath := PATH.Join(path, "public")
+	privPath := PATH.Join(path, "private")
+
+	key, err := readGpgKey(pubPath, providerMeta)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("no public key found at %q", pubPath)
+	}
+
+	privateKey, err := versionedSecret(latestSecretVersion, privPath, providerMeta)
+	if err != nil {
+		return nil, fmt.Errorf("error reading from Vault: %s", err)
+	}
+	if privateKey == nil {
+		return nil, fmt.Errorf("no private key found at %q", privPath)
+	}
+
+	var name string
+	for _, identity := range key.Identities {
+		name = identity.UserId.Name
+		break
+	}
+
+	d.Set("name", name)
+	d.Set("path", path)
+	d.Set("create_date", key.PrimaryKey.CreationTime.UTC().Format("2006-01-02"))
+	d.Set("privatekey_path", privPath)
+	d.Set("publickey_path", pubPath)
+	d.Set("namespace", providerMeta.namespace)
+
+	return []*schema.ResourceData{d}, nil
+}
+
+func gpgResourceUpdate(d *schema.ResourceData, meta interface{}) error {
+	return gpgResourceRead(d, meta)
+}
+
+func gpgResourceDelete(d *schema.ResourceData, meta interface{}) error {
+
+	providerMeta, path, err := resourceIDMeta(d, meta)
+	if err != nil {
+		return err
 	}
 
 	log.Printf("[DEBUG] Delete %s from Vault", path)
 
-	err = deleteSecretCascade(path, meta)
+	err = deleteSecretCascade(path, resourceDeletionMode(d, providerMeta), providerMeta)
 	if err != nil {
 		return fmt.Errorf("error deleting path: %s", err)
 	}
@@ -0,0 +1,64 @@
+package secretmgr
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func TestGpgResourceDrift(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+	r := resourceGpg()
+
+	config := map[string]interface{}{
+		"name":        "ci",
+		"path":        "secret/gpg/ci",
+		"create_date": "v1",
+	}
+	state, err := applyResource(t, r, nil, config, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint := state.Attributes["fingerprint"]
+
+	diff, err := r.Diff(context.Background(), refreshResource(t, r, state, meta), terraform.NewResourceConfigRaw(config), meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.Empty() {
+		t.Fatalf("expected no changes, got %v", diff)
+	}
+
+	// Replace the key outside Terraform.
+	createTestGpgKey(t, "other", meta)
+	other := f.v2["gpg/other/public"]
+	f.v2["gpg/ci/public"] = append(f.v2["gpg/ci/public"], other[len(other)-1])
+
+	state = refreshResource(t, r, state, meta)
+	if state == nil || state.Attributes["fingerprint"] != fingerprint {
+		t.Fatalf("expected the key to stay in state with the fingerprint Terraform created, got %v", state)
+	}
+
+	diff, err = r.Diff(context.Background(), state, terraform.NewResourceConfigRaw(config), meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.RequiresNew() {
+		t.Fatalf("expected the replaced key to be replaced, got %v", diff)
+	}
+
+	state, err = applyResource(t, r, state, config, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := readGpgKey("secret/gpg/ci/public", meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprintf("%X", key.PrimaryKey.Fingerprint); got != state.Attributes["fingerprint"] || got == fingerprint {
+		t.Fatalf("expected a new key with fingerprint %s in state, got %s", state.Attributes["fingerprint"], got)
+	}
+}
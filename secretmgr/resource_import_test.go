@@ -0,0 +1,110 @@
+package secretmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// importState imports id with r like terraform import does, and returns the
+// imported state.
+func importState(t *testing.T, r *schema.Resource, id string, meta *ProviderMeta) *terraform.InstanceState {
+	t.Helper()
+
+	d := r.Data(nil)
+	d.SetId(id)
+	imported, err := r.Importer.State(d, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Read(imported[0], meta); err != nil {
+		t.Fatal(err)
+	}
+	if imported[0].Id() == "" {
+		t.Fatalf("%s was removed from state after its import", id)
+	}
+
+	return imported[0].State()
+}
+
+// importResource imports id with r, then returns the diff of the imported
+// state against raw.
+func importResource(t *testing.T, r *schema.Resource, id string, raw map[string]interface{}, meta *ProviderMeta) *terraform.InstanceDiff {
+	t.Helper()
+
+	diff, err := r.Diff(context.Background(), importState(t, r, id, meta), terraform.NewResourceConfigRaw(raw), meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return diff
+}
+
+func TestDecryptAwsSecretResourceImport(t *testing.T) {
+	meta := newFakeKV(t).meta()
+
+	payLoad := map[string]interface{}{
+		"AWS_ACCESS_KEY": "AKIAEXAMPLE",
+		"AWS_SECRET_KEY": "secret-key",
+	}
+	if err := addVersionedSecret("secret/aws/ci", &payLoad, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	diff := importResource(t, resourceDecryptAwsSecret(), "secret/aws/ci", map[string]interface{}{
+		"access_key":       "AKIAEXAMPLE",
+		"encrypted_secret": "d2NjZXB0ZWQ=",
+		"path":             "secret/aws/ci",
+		"gpg_private_path": "secret/gpg/ci/private",
+	}, meta)
+	if diff.RequiresNew() {
+		t.Fatalf("expected the imported resource to be kept, got %v", diff)
+	}
+
+	diff = importResource(t, resourceDecryptAwsSecret(), "secret/aws/ci", map[string]interface{}{
+		"access_key":       "AKIAOTHER",
+		"encrypted_secret": "d2NjZXB0ZWQ=",
+		"path":             "secret/aws/ci",
+		"gpg_private_path": "secret/gpg/ci/private",
+	}, meta)
+	if !diff.RequiresNew() {
+		t.Fatal("expected a different access_key to replace the imported resource")
+	}
+}
+
+func TestGpgResourceImport(t *testing.T) {
+	meta := newFakeKV(t).meta()
+	r := resourceGpg()
+
+	d := schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{
+		"name":        "ci",
+		"path":        "secret/gpg/ci",
+		"create_date": "v1",
+	})
+	if err := r.Create(d, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	today := time.Now().UTC()
+	for _, createDate := range []string{today.Format("2006-01-02"), today.Format("2006/01/02")} {
+		diff := importResource(t, r, "secret/gpg/ci", map[string]interface{}{
+			"name":        "ci",
+			"path":        "secret/gpg/ci",
+			"create_date": createDate,
+		}, meta)
+		if diff.RequiresNew() {
+			t.Fatalf("expected create_date %s to keep the imported key, got %v", createDate, diff)
+		}
+	}
+
+	diff := importResource(t, r, "secret/gpg/ci", map[string]interface{}{
+		"name":        "ci",
+		"path":        "secret/gpg/ci",
+		"create_date": "2001-01-01",
+	}, meta)
+	if !diff.RequiresNew() {
+		t.Fatal("expected another create_date to replace the imported key")
+	}
+}
@@ -0,0 +1,198 @@
+package secretmgr
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+// kvMetadataSettingsSchema returns the schema of the KV v2 metadata settings,
+// shared by secretmgr_kv_metadata and the secret_metadata block of
+// secretmgr_user.
+func kvMetadataSettingsSchema() map[string]*schema.Schema {
+	return map[string]*schema.Schema{
+		"max_versions": {
+			Type:         schema.TypeInt,
+			Optional:     true,
+			Default:      0,
+			ValidateFunc: validation.IntAtLeast(0),
+			Description:  "Number of versions kept. 0 uses the mount setting.",
+		},
+		"cas_required": {
+			Type:        schema.TypeBool,
+			Optional:    true,
+			Default:     false,
+			Description: "Require check-and-set on every write.",
+		},
+		"delete_version_after": {
+			Type:             schema.TypeString,
+			Optional:         true,
+			Default:          "0s",
+			ValidateFunc:     validateDuration,
+			DiffSuppressFunc: suppressDurationDiff,
+			Description:      "Duration after which versions are deleted, e.g. 720h. 0s uses the mount setting.",
+		},
+		"custom_metadata": {
+			Type:        schema.TypeMap,
+			Optional:    true,
+			Elem:        &schema.Schema{Type: schema.TypeString},
+			Description: "Custom metadata of the secret.",
+		},
+	}
+}
+
+func resourceKvMetadata() *schema.Resource {
+	s := kvMetadataSettingsSchema()
+	s["path"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Required:    true,
+		ForceNew:    true,
+		Description: "Full path of the secret, including its mount.",
+	}
+	s["namespace"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		Computed:    true,
+		ForceNew:    true,
+		Description: "Vault namespace of the secret. Defaults to the provider namespace.",
+	}
+
+	return &schema.Resource{
+		SchemaVersion: 1,
+
+		Create: kvMetadataResourceCreate,
+		Update: kvMetadataResourceUpdate,
+		Delete: kvMetadataResourceDelete,
+		Read:   kvMetadataResourceRead,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: s,
+	}
+}
+
+func validateDuration(v interface{}, k string) ([]string, []error) {
+	if _, err := time.ParseDuration(v.(string)); err != nil {
+		return nil, []error{fmt.Errorf("%q must be a duration: %s", k, err)}
+	}
+	return nil, nil
+}
+
+// suppressDurationDiff ignores differences in how the same duration is
+// written, e.g. 1h and 1h0m0s as returned by Vault.
+func suppressDurationDiff(k, old, new string, d *schema.ResourceData) bool {
+	oldDuration, err := time.ParseDuration(old)
+	if err != nil {
+		return false
+	}
+	newDuration, err := time.ParseDuration(new)
+	if err != nil {
+		return false
+	}
+	return oldDuration == newDuration
+}
+
+// kvMetadataSettings returns the KV v2 metadata settings of d.
+func kvMetadataSettings(d *schema.ResourceData) map[string]interface{} {
+	return map[string]interface{}{
+		"max_versions":         d.Get("max_versions"),
+		"cas_required":         d.Get("cas_required"),
+		"delete_version_after": d.Get("delete_version_after"),
+		"custom_metadata":      d.Get("custom_metadata"),
+	}
+}
+
+func kvMetadataResourceCreate(d *schema.ResourceData, meta interface{}) error {
+	providerMeta, err := resourceCreateMeta(d, meta)
+	if err != nil {
+		return err
+	}
+
+	path := d.Get("path").(string)
+
+	if err := writeSecretMetadata(path, kvMetadataSettings(d), providerMeta); err != nil {
+		return err
+	}
+
+	d.Set("namespace", providerMeta.namespace)
+	d.SetId(providerMeta.resourceID(path))
+
+	return kvMetadataResourceRead(d, meta)
+}
+
+func kvMetadataResourceUpdate(d *schema.ResourceData, meta interface{}) error {
+	providerMeta, path, err := resourceIDMeta(d, meta)
+	if err != nil {
+		return err
+	}
+
+	if err := writeSecretMetadata(path, kvMetadataSettings(d), providerMeta); err != nil {
+		return err
+	}
+
+	return kvMetadataResourceRead(d, meta)
+}
+
+func kvMetadataResourceRead(d *schema.ResourceData, meta interface{}) error {
+	providerMeta, path, err := resourceIDMeta(d, meta)
+	if err != nil {
+		return err
+	}
+
+	metadata, err := readSecretMetadata(path, providerMeta)
+	if err != nil {
+		return err
+	}
+	if metadata == nil {
+		log.Printf("[WARN] metadata of (%s) not found, removing from state", path)
+		d.SetId("")
+		return nil
+	}
+
+	customMetadata := map[string]string{}
+	if custom, ok := metadata["custom_metadata"].(map[string]interface{}); ok {
+		for k, v := range custom {
+			customMetadata[k] = fmt.Sprint(v)
+		}
+	}
+
+	deleteVersionAfter, _ := metadata["delete_version_after"].(string)
+	if deleteVersionAfter == "" {
+		deleteVersionAfter = "0s"
+	}
+
+	d.Set("path", path)
+	d.Set("namespace", providerMeta.namespace)
+	d.Set("max_versions", metadataInt(metadata, "max_versions"))
+	d.Set("cas_required", metadata["cas_required"] == true)
+	d.Set("delete_version_after", deleteVersionAfter)
+	d.Set("custom_metadata", customMetadata)
+
+	return nil
+}
+
+// kvMetadataResourceDelete resets the metadata settings instead of deleting
+// the metadata, which would destroy every version of the secret.
+func kvMetadataResourceDelete(d *schema.ResourceData, meta interface{}) error {
+	providerMeta, path, err := resourceIDMeta(d, meta)
+	if err != nil {
+		return err
+	}
+
+	return writeSecretMetadata(path, defaultKvMetadataSettings(), providerMeta)
+}
+
+// defaultKvMetadataSettings returns the KV v2 metadata settings of a secret
+// that never had any set.
+func defaultKvMetadataSettings() map[string]interface{} {
+	return map[string]interface{}{
+		"max_versions":         0,
+		"cas_required":         false,
+		"delete_version_after": "0s",
+		"custom_metadata":      map[string]interface{}{},
+	}
+}
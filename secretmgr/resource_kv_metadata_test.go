@@ -0,0 +1,115 @@
+package secretmgr
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// applyResource plans and applies raw over state with r like terraform apply
+// does, and returns the new state.
+func applyResource(t *testing.T, r *schema.Resource, state *terraform.InstanceState, raw map[string]interface{}, meta interface{}) (*terraform.InstanceState, error) {
+	t.Helper()
+
+	diff, err := r.Diff(context.Background(), state, terraform.NewResourceConfigRaw(raw), meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff == nil {
+		return state, nil
+	}
+
+	newState, diags := r.Apply(context.Background(), state, diff, meta)
+	if diags.HasError() {
+		return newState, errorFromDiags(diags)
+	}
+	return newState, nil
+}
+
+// refreshResource refreshes state with r like terraform refresh does.
+func refreshResource(t *testing.T, r *schema.Resource, state *terraform.InstanceState, meta interface{}) *terraform.InstanceState {
+	t.Helper()
+
+	newState, diags := r.RefreshWithoutUpgrade(context.Background(), state, meta)
+	if diags.HasError() {
+		t.Fatal(errorFromDiags(diags))
+	}
+	return newState
+}
+
+func TestKvMetadataResource(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+	r := resourceKvMetadata()
+
+	if err := addVersionedSecret("secret/a", &map[string]interface{}{"k": "v"}, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := applyResource(t, r, nil, map[string]interface{}{
+		"path":                 "secret/a",
+		"max_versions":         5,
+		"delete_version_after": "1h",
+		"custom_metadata":      map[string]interface{}{"owner": "team"},
+	}, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ID != "secret/a" || state.Attributes["max_versions"] != "5" || state.Attributes["custom_metadata.owner"] != "team" {
+		t.Fatalf("unexpected state after create: %v", state)
+	}
+
+	// Update.
+	state, err = applyResource(t, r, state, map[string]interface{}{
+		"path":                 "secret/a",
+		"cas_required":         true,
+		"delete_version_after": "60m",
+	}, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := f.settings["a"]
+	if settings["max_versions"] != float64(0) || settings["cas_required"] != true || len(settings["custom_metadata"].(map[string]interface{})) != 0 {
+		t.Fatalf("unexpected metadata after update: %v", settings)
+	}
+
+	// Read picks up changes made outside Terraform.
+	f.settings["a"]["max_versions"] = 3
+	f.settings["a"]["custom_metadata"] = map[string]interface{}{"owner": "other"}
+	state = refreshResource(t, r, state, meta)
+	if state.Attributes["max_versions"] != "3" || state.Attributes["custom_metadata.owner"] != "other" {
+		t.Fatalf("expected the changes made outside Terraform to be read, got %v", state.Attributes)
+	}
+
+	// Delete resets the settings and keeps the versions.
+	if err := r.Delete(r.Data(state), meta); err != nil {
+		t.Fatal(err)
+	}
+	settings = f.settings["a"]
+	if settings["max_versions"] != float64(0) || settings["cas_required"] != false || settings["delete_version_after"] != "0s" {
+		t.Fatalf("expected the metadata to be reset, got %v", settings)
+	}
+	if len(f.v2["a"]) != 1 || f.deleted["a"][1] || f.destroyed["a"][1] {
+		t.Fatal("expected the secret to be kept")
+	}
+
+	// Read drops metadata deleted outside Terraform.
+	delete(f.v2, "a")
+	delete(f.settings, "a")
+	if state = refreshResource(t, r, state, meta); state != nil {
+		t.Fatalf("expected the resource to be removed from state, got %v", state)
+	}
+}
+
+func errorFromDiags(diags diag.Diagnostics) error {
+	var msgs []string
+	for _, d := range diags {
+		msgs = append(msgs, d.Summary)
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
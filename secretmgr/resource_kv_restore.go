@@ -0,0 +1,107 @@
+package secretmgr
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func resourceKvRestore() *schema.Resource {
+	return &schema.Resource{
+		SchemaVersion: 1,
+
+		Create: kvRestoreResourceCreate,
+		Delete: kvRestoreResourceDelete,
+		Read:   kvRestoreResourceRead,
+
+		Schema: map[string]*schema.Schema{
+			"archive_path": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Full path of the archive, including its mount, e.g. as written by archive_on_destroy of secretmgr_user.",
+			},
+			"path": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Full path, including its mount, the archive is copied back to.",
+			},
+			"namespace": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "Vault namespace of the archive and the secrets. Defaults to the provider namespace.",
+			},
+			"restored_count": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Number of secrets restored.",
+			},
+		},
+	}
+}
+
+func kvRestoreResourceCreate(d *schema.ResourceData, meta interface{}) error {
+	providerMeta, err := resourceCreateMeta(d, meta)
+	if err != nil {
+		return err
+	}
+
+	archivePath := d.Get("archive_path").(string)
+	path := d.Get("path").(string)
+
+	count, err := copySecretTree(archivePath, path, providerMeta)
+	if err != nil {
+		return fmt.Errorf("error restoring %q to %q: %s", archivePath, path, err)
+	}
+	if count == 0 {
+		return fmt.Errorf("no secret found under %q", archivePath)
+	}
+
+	log.Printf("[INFO] Restored %d secrets of %s to %s", count, archivePath, path)
+
+	d.Set("restored_count", count)
+	d.Set("namespace", providerMeta.namespace)
+	d.SetId(providerMeta.resourceID(path))
+
+	return kvRestoreResourceRead(d, meta)
+}
+
+func kvRestoreResourceRead(d *schema.ResourceData, meta interface{}) error {
+	providerMeta, path, err := resourceIDMeta(d, meta)
+	if err != nil {
+		return err
+	}
+
+	secrets, _, err := listSecretTree(path, 0, providerMeta)
+	if err != nil {
+		return err
+	}
+	if len(secrets) == 0 {
+		secret, err := versionedSecret(latestSecretVersion, path, providerMeta)
+		if err != nil {
+			return fmt.Errorf("error reading from Vault: %s", err)
+		}
+		if secret == nil {
+			log.Printf("[WARN] restored secrets (%s) not found, removing from state", path)
+			d.SetId("")
+			return nil
+		}
+	}
+
+	d.Set("path", path)
+	d.Set("namespace", providerMeta.namespace)
+
+	return nil
+}
+
+// kvRestoreResourceDelete leaves the restored secrets as they are, the
+// restore is only forgotten.
+func kvRestoreResourceDelete(d *schema.ResourceData, meta interface{}) error {
+	log.Printf("[DEBUG] Removing restore of %s from state", d.Id())
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package secretmgr
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+func resourceKvRollback() *schema.Resource {
+	return &schema.Resource{
+		SchemaVersion: 1,
+
+		Create: kvRollbackResourceCreate,
+		Delete: kvRollbackResourceDelete,
+		Read:   kvRollbackResourceRead,
+
+		Schema: map[string]*schema.Schema{
+			"path": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Full path of the secret, including its mount.",
+			},
+			"version": {
+				Type:         schema.TypeInt,
+				Required:     true,
+				ForceNew:     true,
+				ValidateFunc: validation.IntAtLeast(1),
+				Description:  "Version of the secret to restore.",
+			},
+			"namespace": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "Vault namespace of the secret. Defaults to the provider namespace.",
+			},
+			"new_version": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Version of the secret created by the rollback.",
+			},
+		},
+	}
+}
+
+func kvRollbackResourceCreate(d *schema.ResourceData, meta interface{}) error {
+	providerMeta, err := resourceCreateMeta(d, meta)
+	if err != nil {
+		return err
+	}
+
+	path := d.Get("path").(string)
+	version := d.Get("version").(int)
+
+	metadata, err := readSecretMetadata(path, providerMeta)
+	if err != nil {
+		return err
+	}
+	if metadata == nil {
+		return fmt.Errorf("no secret found at %q", path)
+	}
+
+	versions, _ := metadata["versions"].(map[string]interface{})
+	versionMetadata, ok := versions[strconv.Itoa(version)].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("version %d of %q does not exist", version, path)
+	}
+	if versionMetadata["destroyed"] == true {
+		return fmt.Errorf("version %d of %q was destroyed and cannot be restored", version, path)
+	}
+
+	if versionDeleted(versionMetadata) {
+		mountPath, _, err := isKVv2(path, providerMeta)
+		if err != nil {
+			return fmt.Errorf("error determining if it's a v2 path: %s", err)
+		}
+		undeletePath := addPrefixToVKVPath(path, mountPath, "undelete")
+
+		log.Printf("[DEBUG] Undeleting version %d of %s in Vault", version, undeletePath)
+		_, err = providerMeta.client.Logical().Write(undeletePath, map[string]interface{}{
+			"versions": []int{version},
+		})
+		if err != nil {
+			return fmt.Errorf("error undeleting %q in Vault: %s", undeletePath, err)
+		}
+	}
+
+	secret, err := versionedSecret(version, path, providerMeta)
+	if err != nil {
+		return fmt.Errorf("error reading from Vault: %s", err)
+	}
+	if secret == nil {
+		return fmt.Errorf("version %d of %q has no data", version, path)
+	}
+
+	// Only write if no version was added since the metadata was read.
+	options := map[string]interface{}{
+		"cas": metadataInt(metadata, "current_version"),
+	}
+
+	log.Printf("[DEBUG] Restoring version %d of %s", version, path)
+	written, err := writeVersionedSecret(path, &secret.Data, options, providerMeta)
+	if isCASMismatch(err) {
+		return fmt.Errorf("secret %q was modified during the rollback: %s", path, err)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing %q to Vault: %s", path, err)
+	}
+	if written != nil {
+		d.Set("new_version", metadataInt(written.Data, "version"))
+	}
+
+	d.Set("namespace", providerMeta.namespace)
+	d.SetId(providerMeta.resourceID(path))
+
+	return kvRollbackResourceRead(d, meta)
+}
+
+func kvRollbackResourceRead(d *schema.ResourceData, meta interface{}) error {
+	providerMeta, path, err := resourceIDMeta(d, meta)
+	if err != nil {
+		return err
+	}
+
+	metadata, err := readSecretMetadata(path, providerMeta)
+	if err != nil {
+		return err
+	}
+	if metadata == nil {
+		log.Printf("[WARN] secret (%s) not found, removing from state", path)
+		d.SetId("")
+		return nil
+	}
+
+	d.Set("path", path)
+	d.Set("namespace", providerMeta.namespace)
+
+	return nil
+}
+
+// kvRollbackResourceDelete leaves the secret as it is, the rollback is only
+// forgotten.
+func kvRollbackResourceDelete(d *schema.ResourceData, meta interface{}) error {
+	log.Printf("[DEBUG] Removing rollback of %s from state", d.Id())
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package secretmgr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// rollbackTestSecret writes the versions v1, v2 and v3 of secret/a.
+func rollbackTestSecret(t *testing.T, meta *ProviderMeta) {
+	t.Helper()
+
+	for _, v := range []string{"v1", "v2", "v3"} {
+		if err := addVersionedSecret("secret/a", &map[string]interface{}{"k": v}, meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func rollback(t *testing.T, meta *ProviderMeta, version int) (*schema.ResourceData, error) {
+	r := resourceKvRollback()
+	d := schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{
+		"path":    "secret/a",
+		"version": version,
+	})
+	return d, r.Create(d, meta)
+}
+
+func (f *fakeKV) requested(request string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, r := range f.requests {
+		if r == request {
+			return true
+		}
+	}
+	return false
+}
+
+func TestKvRollbackResource(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+	rollbackTestSecret(t, meta)
+
+	d, err := rollback(t, meta, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Get("new_version") != 4 || f.v2["a"][3]["k"] != "v1" {
+		t.Fatalf("expected version 1 to be written as version 4, got %v, %v", d.Get("new_version"), f.v2["a"])
+	}
+	if f.requested("PUT secret/undelete/a") {
+		t.Fatal("expected a live version not to be undeleted")
+	}
+}
+
+func TestKvRollbackResourceDeletedVersion(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+	rollbackTestSecret(t, meta)
+	markVersions(f.deleted, "a", []int{2}, true)
+
+	d, err := rollback(t, meta, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.requested("PUT secret/undelete/a") || f.deleted["a"][2] {
+		t.Fatal("expected the deleted version to be undeleted")
+	}
+	if d.Get("new_version") != 4 || f.v2["a"][3]["k"] != "v2" {
+		t.Fatalf("expected version 2 to be written as version 4, got %v, %v", d.Get("new_version"), f.v2["a"])
+	}
+}
+
+func TestKvRollbackResourceDeleteVersionAfter(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+	rollbackTestSecret(t, meta)
+	f.settings["a"] = map[string]interface{}{"delete_version_after": "720h"}
+
+	// Every live version has a deletion_time in the future, undeleting one
+	// would clear it.
+	if _, err := rollback(t, meta, 1); err != nil {
+		t.Fatal(err)
+	}
+	if f.requested("PUT secret/undelete/a") {
+		t.Fatal("expected a version scheduled for deletion not to be undeleted")
+	}
+	if f.v2["a"][3]["k"] != "v1" {
+		t.Fatalf("expected version 1 to be restored, got %v", f.v2["a"])
+	}
+}
+
+func TestKvRollbackResourceDestroyedVersion(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+	rollbackTestSecret(t, meta)
+	markVersions(f.destroyed, "a", []int{1}, true)
+
+	_, err := rollback(t, meta, 1)
+	if err == nil || !strings.Contains(err.Error(), "was destroyed and cannot be restored") {
+		t.Fatalf("expected a destroyed version error, got %v", err)
+	}
+	if len(f.v2["a"]) != 3 {
+		t.Fatalf("expected nothing to be written, got %v", f.v2["a"])
+	}
+}
+
+func TestKvRollbackResourceCASMismatch(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+	rollbackTestSecret(t, meta)
+
+	// Another writer adds a version once the metadata has been read.
+	f.beforeRequest = func(method, p string) {
+		if method == "GET" && p == "secret/data/a" {
+			f.v2["a"] = append(f.v2["a"], map[string]interface{}{"k": "other"})
+			f.beforeRequest = nil
+		}
+	}
+
+	_, err := rollback(t, meta, 1)
+	if err == nil || !strings.Contains(err.Error(), "was modified during the rollback") {
+		t.Fatalf("expected a modified during the rollback error, got %v", err)
+	}
+	if got := f.v2["a"]; got[len(got)-1]["k"] != "other" {
+		t.Fatalf("expected the other write to be kept, got %v", got)
+	}
+}
@@ -0,0 +1,220 @@
+package secretmgr
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/structure"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+func resourceKvSecret() *schema.Resource {
+	return &schema.Resource{
+		SchemaVersion: 1,
+
+		Create: kvSecretResourceCreate,
+		Update: kvSecretResourceUpdate,
+		Delete: kvSecretResourceDelete,
+		Read:   kvSecretResourceRead,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"path": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Full path of the secret, including its mount.",
+			},
+			"data_json": {
+				Type:             schema.TypeString,
+				Required:         true,
+				Sensitive:        true,
+				ValidateFunc:     validation.StringIsJSON,
+				DiffSuppressFunc: structure.SuppressJsonDiff,
+				StateFunc:        normalizeDataJSON,
+				Description:      "JSON-encoded object written to the secret.",
+			},
+			"cas": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Description: "Only write the secret if its current version matches, on the first write after cas is set or changed. 0 only writes the secret if it does not exist. KV v2 only.",
+			},
+			"force_overwrite": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Write the secret even if it was modified outside Terraform since Terraform last wrote it. KV v2 only.",
+			},
+			"version": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Version of the secret last written by Terraform. KV v2 only.",
+			},
+			"delete_all_versions": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Delete all versions and metadata of the secret instead of its latest version. KV v2 only.",
+			},
+			"namespace": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "Vault namespace of the secret. Defaults to the provider namespace.",
+			},
+		},
+	}
+}
+
+func normalizeDataJSON(v interface{}) string {
+	normalized, _ := structure.NormalizeJsonString(v)
+	return normalized
+}
+
+func kvSecretResourceCreate(d *schema.ResourceData, meta interface{}) error {
+	providerMeta, err := resourceCreateMeta(d, meta)
+	if err != nil {
+		return err
+	}
+
+	path := d.Get("path").(string)
+
+	var options map[string]interface{}
+	if cas, ok := d.GetOkExists("cas"); ok {
+		options = map[string]interface{}{
+			"cas": cas.(int),
+		}
+	}
+
+	if err := kvSecretWrite(d, path, options, providerMeta); err != nil {
+		return err
+	}
+
+	d.Set("namespace", providerMeta.namespace)
+	d.SetId(providerMeta.resourceID(path))
+
+	return kvSecretResourceRead(d, meta)
+}
+
+func kvSecretResourceUpdate(d *schema.ResourceData, meta interface{}) error {
+	providerMeta, path, err := resourceIDMeta(d, meta)
+	if err != nil {
+		return err
+	}
+
+	if d.HasChange("data_json") {
+		// A configured cas only applies to the first write after it is set,
+		// later writes check the version Terraform last wrote.
+		var options map[string]interface{}
+		if cas, ok := d.GetOkExists("cas"); ok && d.HasChange("cas") {
+			options = map[string]interface{}{
+				"cas": cas.(int),
+			}
+		} else if !d.Get("force_overwrite").(bool) && d.Get("version").(int) > 0 {
+			options = map[string]interface{}{
+				"cas": d.Get("version").(int),
+			}
+		}
+
+		if err := kvSecretWrite(d, path, options, providerMeta); err != nil {
+			// Keep the previous data_json in state so the change is planned
+			// again.
+			d.Partial(true)
+			return err
+		}
+	}
+
+	return kvSecretResourceRead(d, meta)
+}
+
+// kvSecretWrite writes the data_json of d to path and records the version it
+// created.
+func kvSecretWrite(d *schema.ResourceData, path string, options map[string]interface{}, meta *ProviderMeta) error {
+	payLoad, err := structure.ExpandJsonFromString(d.Get("data_json").(string))
+	if err != nil {
+		return fmt.Errorf("error parsing data_json: %s", err)
+	}
+
+	secret, err := writeVersionedSecret(path, &payLoad, options, meta)
+	if isCASMismatch(err) {
+		return fmt.Errorf("secret %q was modified outside Terraform since version %v, "+
+			"set force_overwrite to overwrite it: %s", path, options["cas"], err)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing %q to Vault: %s", path, err)
+	}
+
+	if secret != nil {
+		d.Set("version", metadataInt(secret.Data, "version"))
+	}
+
+	return nil
+}
+
+func kvSecretResourceRead(d *schema.ResourceData, meta interface{}) error {
+	providerMeta, path, err := resourceIDMeta(d, meta)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] Reading %s from Vault", path)
+	secret, metadata, err := versionedSecretWithMetadata(latestSecretVersion, path, providerMeta)
+	if err != nil {
+		return fmt.Errorf("error reading from Vault: %s", err)
+	}
+	if secret == nil {
+		log.Printf("[WARN] secret (%s) not found, removing from state", path)
+		d.SetId("")
+		return nil
+	}
+
+	dataJSON, err := structure.FlattenJsonToString(secret.Data)
+	if err != nil {
+		return fmt.Errorf("error encoding data of %q: %s", path, err)
+	}
+
+	d.Set("path", path)
+	d.Set("data_json", dataJSON)
+	d.Set("namespace", providerMeta.namespace)
+
+	// The version is only refreshed when unknown, e.g. after an import, so
+	// that the next write detects changes made outside Terraform.
+	if d.Get("version").(int) == 0 {
+		d.Set("version", metadataInt(metadata, "version"))
+	}
+
+	return nil
+}
+
+func kvSecretResourceDelete(d *schema.ResourceData, meta interface{}) error {
+	providerMeta, path, err := resourceIDMeta(d, meta)
+	if err != nil {
+		return err
+	}
+
+	mountPath, v2, err := isKVv2(path, providerMeta)
+	if err != nil {
+		return fmt.Errorf("error determining if it's a v2 path: %s", err)
+	}
+
+	if v2 {
+		if d.Get("delete_all_versions").(bool) {
+			path = addPrefixToVKVPath(path, mountPath, "metadata")
+		} else {
+			path = addPrefixToVKVPath(path, mountPath, "data")
+		}
+	}
+
+	log.Printf("[DEBUG] Delete %s from Vault", path)
+
+	_, err = providerMeta.client.Logical().Delete(path)
+	if err != nil {
+		return fmt.Errorf("error deleting %q from Vault: %s", path, err)
+	}
+
+	return nil
+}
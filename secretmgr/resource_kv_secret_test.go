@@ -0,0 +1,77 @@
+package secretmgr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestKvSecretResourceCreateCAS(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+	r := resourceKvSecret()
+
+	d := schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{
+		"path":      "secret/a",
+		"data_json": `{"k": "v1"}`,
+		"cas":       0,
+	})
+	if err := r.Create(d, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	d = schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{
+		"path":      "secret/a",
+		"data_json": `{"k": "v2"}`,
+		"cas":       0,
+	})
+	err := r.Create(d, meta)
+	if err == nil || !strings.Contains(err.Error(), "modified outside Terraform") {
+		t.Fatalf("expected cas = 0 to refuse overwriting secret/a, got %v", err)
+	}
+}
+
+func TestKvSecretResourceUpdateCAS(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+	r := resourceKvSecret()
+
+	d := schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{
+		"path":      "secret/a",
+		"data_json": `{"k": "v1"}`,
+		"cas":       0,
+	})
+	if err := r.Create(d, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	// The configured cas was used up by the first write.
+	for i, v := range []string{`{"k": "v2"}`, `{"k": "v3"}`} {
+		d.Set("data_json", v)
+		if err := r.Update(d, meta); err != nil {
+			t.Fatal(err)
+		}
+		if got := d.Get("version"); got != i+2 {
+			t.Fatalf("expected version %d, got %v", i+2, got)
+		}
+	}
+
+	if err := addVersionedSecret("secret/a", &map[string]interface{}{"k": "outside"}, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	d.Set("data_json", `{"k": "v4"}`)
+	err := r.Update(d, meta)
+	if err == nil || !strings.Contains(err.Error(), "modified outside Terraform") {
+		t.Fatalf("expected a modified outside Terraform error, got %v", err)
+	}
+
+	d.Set("force_overwrite", true)
+	if err := r.Update(d, meta); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.v2["a"]; got[len(got)-1]["k"] != "v4" {
+		t.Fatalf("expected force_overwrite to write v4, got %v", got[len(got)-1])
+	}
+}
@@ -2,12 +2,14 @@ package secretmgr
 
 import (
 	// "encoding/json"
+	"context"
 	"fmt"
 	"log"
 	PATH "path"
+	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
-	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 )
 
 const latestSecretVersion = -1
@@ -17,12 +19,13 @@ func resourceUser() *schema.Resource {
 		SchemaVersion: 1,
 
 		Create: userResourceWrite,
-		// Update: userResourceUpdate,
+		Update: userResourceUpdate,
 		Delete: userResourceDelete,
 		Read:   userResourceRead,
-		// Importer: &schema.ResourceImporter{
-		// 	State: schema.ImportStatePassthrough,
-		// },
+		Importer: &schema.ResourceImporter{
+			State: userResourceImport,
+		},
+		CustomizeDiff: userCustomizeDiff,
 		// MigrateState: resourceUserMigrateState,
 
 		Schema: map[string]*schema.Schema{
@@ -36,31 +39,84 @@ func resourceUser() *schema.Resource {
 				Type:        schema.TypeString,
 				ForceNew:    true,
 				Optional:    true,
-				Default:     "sre-secrets/users",
-				Description: "base_path",
+				Computed:    true,
+				Description: "base_path. Defaults to the provider user_base_path.",
+			},
+			"deletion_mode": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringInSlice(deletionModes, false),
+				Description:  "How KV v2 secrets are deleted: soft, destroy or purge. Defaults to the provider deletion_mode.",
+			},
+			"deletion_protection": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Refuse to destroy the user directory.",
+			},
+			"max_delete_count": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      0,
+				ValidateFunc: validation.IntAtLeast(0),
+				Description:  "Refuse to destroy the user directory when it holds more secrets than this. 0 means no limit.",
+			},
+			"archive_on_destroy": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Copy the user directory under archive_path before destroying it. When a destroy is retried, the newest archive is kept if it still holds every secret left.",
+			},
+			"archive_path": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Full path, including its mount, the user directory is archived under as <base_path>/<name>-<timestamp>. Required by archive_on_destroy.",
+			},
+			"namespace": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "Vault namespace of the secret. Defaults to the provider namespace.",
+			},
+			"secret_metadata": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Elem:        &schema.Resource{Schema: kvMetadataSettingsSchema()},
+				Description: "KV v2 metadata applied to the secrets created in the user directory. Requires a KV v2 mount, removing it resets the metadata.",
 			},
 		},
 	}
 }
 
 func userResourceWrite(d *schema.ResourceData, meta interface{}) error {
-	client := meta.(*api.Client)
+	providerMeta, err := resourceCreateMeta(d, meta)
+	if err != nil {
+		return err
+	}
+	namespace := providerMeta.namespace
 
 	var data map[string]interface{}
 
 	name := d.Get("name").(string)
 	basePath := d.Get("base_path").(string)
+	if basePath == "" {
+		basePath = providerMeta.userBasePath
+	}
 
 	path := PATH.Join(basePath, name)
 	originalPath := path
 
-	mountPath, v2, err := isKVv2(path, client)
+	mountPath, v2, err := isKVv2(path, providerMeta)
 	if err != nil {
 		return fmt.Errorf("error reading if it's a v2 path: %s", err)
 	}
 
 	if v2 {
 		path = addPrefixToVKVPath(path, mountPath, "data")
+	} else if settings := d.Get("secret_metadata").([]interface{}); len(settings) > 0 {
+		return fmt.Errorf("secret_metadata requires a KV v2 mount, %q is not on one", originalPath)
 	}
 
 	examplePath := PATH.Join(path, "example")
@@ -75,26 +131,82 @@ func userResourceWrite(d *schema.ResourceData, meta interface{}) error {
 	}
 
 	log.Printf("[DEBUG] Writing generic Vault secret to %s", path)
-	_, err = client.Logical().Write(examplePath, data)
+	_, err = providerMeta.client.Logical().Write(examplePath, data)
 	if err != nil {
 		return fmt.Errorf("error writing to Vault: %s", err)
 	}
 
-	d.SetId(originalPath)
+	if err := userApplySecretMetadata(d, PATH.Join(originalPath, "example"), providerMeta); err != nil {
+		return err
+	}
+
+	d.Set("base_path", basePath)
+	d.Set("namespace", namespace)
+	d.SetId(providerMeta.resourceID(originalPath))
 
 	return userResourceRead(d, meta)
 }
 
-func userResourceRead(d *schema.ResourceData, meta interface{}) error {
+func userResourceUpdate(d *schema.ResourceData, meta interface{}) error {
+	providerMeta, path, err := resourceIDMeta(d, meta)
+	if err != nil {
+		return err
+	}
 
-	path := d.Id()
+	if d.HasChange("secret_metadata") {
+		if err := userApplySecretMetadata(d, PATH.Join(path, "example"), providerMeta); err != nil {
+			return err
+		}
+	}
+
+	return userResourceRead(d, meta)
+}
 
-	client := meta.(*api.Client)
+// userApplySecretMetadata writes the secret_metadata of d to the secret at
+// path. Once secret_metadata is removed, the metadata settings are reset.
+func userApplySecretMetadata(d *schema.ResourceData, path string, meta *ProviderMeta) error {
+	settings, _ := d.Get("secret_metadata").([]interface{})
+	if len(settings) == 0 || settings[0] == nil {
+		if !d.HasChange("secret_metadata") || d.IsNewResource() {
+			return nil
+		}
+		return writeSecretMetadata(path, defaultKvMetadataSettings(), meta)
+	}
+
+	return writeSecretMetadata(path, settings[0].(map[string]interface{}), meta)
+}
+
+func userResourceImport(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	providerMeta, path, err := resourceIDMeta(d, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	basePath, name := PATH.Split(PATH.Clean(path))
+	basePath = PATH.Clean(basePath)
+	if name == "" || basePath == "." || basePath == "/" {
+		return nil, fmt.Errorf("expected an ID of the form [namespace//]base_path/name, got %q", d.Id())
+	}
+
+	d.Set("name", name)
+	d.Set("base_path", basePath)
+	d.Set("namespace", providerMeta.namespace)
+	d.SetId(providerMeta.resourceID(PATH.Join(basePath, name)))
+
+	return []*schema.ResourceData{d}, nil
+}
+
+func userResourceRead(d *schema.ResourceData, meta interface{}) error {
+
+	providerMeta, path, err := resourceIDMeta(d, meta)
+	if err != nil {
+		return err
+	}
 
 	examplePath := PATH.Join(path, "example")
 
 	log.Printf("[DEBUG] Reading %s from Vault", examplePath)
-	secret, err := versionedSecret(latestSecretVersion, examplePath, client)
+	secret, err := versionedSecret(latestSecretVersion, examplePath, providerMeta)
 
 	if err != nil {
 		return fmt.Errorf("error reading from Vault: %s", err)
@@ -105,59 +217,116 @@ func userResourceRead(d *schema.ResourceData, meta interface{}) error {
 		return nil
 	}
 
-	log.Printf("[DEBUG] secret: %#v", secret)
+	providerMeta.logSecret(examplePath, secret)
+
+	basePath, name := PATH.Split(path)
+	d.Set("name", name)
+	d.Set("base_path", PATH.Clean(basePath))
+	d.Set("namespace", providerMeta.namespace)
 
 	return nil
 }
 
 func userResourceDelete(d *schema.ResourceData, meta interface{}) error {
 
-	path := d.Id()
+	providerMeta, path, err := resourceIDMeta(d, meta)
+	if err != nil {
+		return err
+	}
 
-	client := meta.(*api.Client)
+	archive := d.Get("archive_on_destroy").(bool)
+	archiveRoot := d.Get("archive_path").(string)
+	if archive && archiveRoot == "" {
+		return fmt.Errorf("archive_on_destroy requires archive_path")
+	}
 
-	mountPath, v2, err := isKVv2(path, client)
-	if err != nil {
-		return fmt.Errorf("error determining if it's a v2 path: %s", err)
+	if err := userCheckDelete(d, path, providerMeta); err != nil {
+		return err
 	}
 
-	if v2 {
-		path = addPrefixToVKVPath(path, mountPath, "metadata")
+	if archive {
+		if err := userArchive(path, archiveRoot, providerMeta); err != nil {
+			return err
+		}
 	}
 
 	log.Printf("[DEBUG] Delete %s from Vault", path)
 
-	userResourceDeleteAll(path, meta)
+	err = deleteSecretCascade(path, resourceDeletionMode(d, providerMeta), providerMeta)
+	if err != nil {
+		return fmt.Errorf("error deleting path: %s", err)
+	}
 
 	return nil
 }
 
-func userResourceDeleteAll(path string, meta interface{}) error {
+// userCustomizeDiff requires archive_path along with archive_on_destroy, so
+// that a missing one fails the plan rather than the destroy.
+func userCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if !d.Get("archive_on_destroy").(bool) || !d.NewValueKnown("archive_path") {
+		return nil
+	}
+	if d.Get("archive_path").(string) == "" {
+		return fmt.Errorf("archive_on_destroy requires archive_path")
+	}
+	return nil
+}
 
-	client := meta.(*api.Client)
+// userArchive copies the user directory at path under archiveRoot, unless the
+// newest archive of a destroy that failed afterwards still holds every secret
+// of path.
+func userArchive(path, archiveRoot string, meta *ProviderMeta) error {
+	// Users of the same name under other base paths are archived apart.
+	archiveDir := PATH.Join(archiveRoot, PATH.Dir(path))
+	name := PATH.Base(path)
+
+	latest, err := latestArchive(archiveDir, name, meta)
+	if err != nil {
+		return fmt.Errorf("error listing archives of %q under %q: %s", path, archiveDir, err)
+	}
+	if latest != "" {
+		held, err := archiveHolds(path, latest, meta)
+		if err != nil {
+			return fmt.Errorf("error comparing %q with its archive %q: %s", path, latest, err)
+		}
+		if held {
+			log.Printf("[INFO] %s is already archived to %s, not archiving it again", path, latest)
+			return nil
+		}
+	}
 
-	log.Printf("[DEBUG] listing %s from Vault", path)
+	archivePath := PATH.Join(archiveDir, name+"-"+time.Now().UTC().Format(archiveTimeFormat))
 
-	secret, err := client.Logical().List(path)
+	count, err := copySecretTree(path, archivePath, meta)
 	if err != nil {
-		return fmt.Errorf("error listing %q from Vault: %q", path, err)
+		return fmt.Errorf("error archiving %q to %q: %s", path, archivePath, err)
 	}
+	log.Printf("[INFO] Archived %d secrets of %s to %s", count, path, archivePath)
 
-	for _, v := range secret.Data["keys"].([]interface{}) {
-		key := v.(string)
+	return nil
+}
 
-		log.Printf("[DEBUG] key - %s ", key)
+// userCheckDelete enforces deletion_protection and max_delete_count, listing
+// the secrets the deletion of path would delete.
+func userCheckDelete(d *schema.ResourceData, path string, meta *ProviderMeta) error {
+	protected := d.Get("deletion_protection").(bool)
+	maxDeleteCount := d.Get("max_delete_count").(int)
+	if !protected && maxDeleteCount == 0 {
+		return nil
+	}
 
-		if key[len(key)-1:] == "/" {
-			userResourceDeleteAll(PATH.Join(path, key[:len(key)-1]), meta)
-		} else {
-			subPath := PATH.Join(path, key)
-			log.Printf("[DEBUG] deleting %s from Vault", subPath)
-			_, err = client.Logical().Delete(subPath)
-			if err != nil {
-				return fmt.Errorf("error deleting %q from Vault: %q", subPath, err)
-			}
-		}
+	secrets, _, err := listSecretTree(path, 0, meta)
+	if err != nil {
+		return err
+	}
+
+	if protected {
+		return fmt.Errorf("%q has deletion_protection set, unset it to delete these %d secrets:\n%s",
+			path, len(secrets), formatSecretList(secrets))
+	}
+	if len(secrets) > maxDeleteCount {
+		return fmt.Errorf("deleting %q would delete %d secrets, more than max_delete_count (%d):\n%s",
+			path, len(secrets), maxDeleteCount, formatSecretList(secrets))
 	}
 
 	return nil
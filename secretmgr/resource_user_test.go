@@ -0,0 +1,243 @@
+package secretmgr
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func TestUserResourceArchiveOnDestroyPlan(t *testing.T) {
+	r := resourceUser()
+
+	cases := []struct {
+		raw map[string]interface{}
+		err bool
+	}{
+		{map[string]interface{}{"archive_on_destroy": false}, false},
+		{map[string]interface{}{"archive_on_destroy": true}, true},
+		{map[string]interface{}{"archive_on_destroy": true, "archive_path": "kv1/archive"}, false},
+	}
+
+	for _, c := range cases {
+		c.raw["name"] = "alice"
+		c.raw["base_path"] = "kv1/users"
+		config := terraform.NewResourceConfigRaw(c.raw)
+
+		if diags := r.Validate(config); diags.HasError() {
+			t.Errorf("expected %v to be valid, got %v", c.raw, diags)
+		}
+
+		_, err := r.Diff(context.Background(), nil, config, newFakeKV(t).meta())
+		if c.err && (err == nil || !strings.Contains(err.Error(), "requires archive_path")) {
+			t.Errorf("expected planning %v to fail, got %v", c.raw, err)
+		}
+		if !c.err && err != nil {
+			t.Errorf("expected planning %v to succeed, got %s", c.raw, err)
+		}
+	}
+}
+
+// userDestroyData returns the data of the user at path to destroy with raw.
+func userDestroyData(t *testing.T, path string, raw map[string]interface{}) *schema.ResourceData {
+	d := schema.TestResourceDataRaw(t, resourceUser().Schema, raw)
+	d.SetId(path)
+	return d
+}
+
+func TestUserResourceDeleteArchive(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+
+	writeUser := func() {
+		f.v1["kv1/users/alice/example"] = map[string]interface{}{"value": "x"}
+		f.v1["kv1/users/alice/ssh/key"] = map[string]interface{}{"value": "y"}
+	}
+	archives := func() []interface{} {
+		return f.list("kv1/archive/kv1/users", f.v1Keys())
+	}
+
+	writeUser()
+	err := userResourceDelete(userDestroyData(t, "kv1/users/alice", map[string]interface{}{
+		"archive_on_destroy": true,
+	}), meta)
+	if err == nil || !strings.Contains(err.Error(), "requires archive_path") {
+		t.Fatalf("expected a missing archive_path error, got %v", err)
+	}
+	if len(f.v1) != 2 {
+		t.Fatalf("expected the user to be kept, got %v", f.v1)
+	}
+
+	raw := map[string]interface{}{
+		"archive_on_destroy": true,
+		"archive_path":       "kv1/archive",
+	}
+	if err := userResourceDelete(userDestroyData(t, "kv1/users/alice", raw), meta); err != nil {
+		t.Fatal(err)
+	}
+	if keys := archives(); len(keys) != 1 {
+		t.Fatalf("expected one archive, got %v", keys)
+	}
+	archive := "kv1/archive/kv1/users/" + strings.TrimSuffix(archives()[0].(string), "/")
+	if f.v1[archive+"/ssh/key"]["value"] != "y" {
+		t.Fatalf("expected %s/ssh/key to be archived, got %v", archive, f.v1)
+	}
+	if _, ok := f.v1["kv1/users/alice/example"]; ok {
+		t.Fatal("expected the user to be deleted")
+	}
+
+	// A destroy retried after a failed deletion keeps the archive already written.
+	f.v1["kv1/users/alice/ssh/key"] = map[string]interface{}{"value": "y"}
+	if err := userResourceDelete(userDestroyData(t, "kv1/users/alice", raw), meta); err != nil {
+		t.Fatal(err)
+	}
+	if keys := archives(); len(keys) != 1 {
+		t.Fatalf("expected the archive to be kept, got %v", keys)
+	}
+
+	// A user changed since its newest archive is archived again. The archive
+	// is moved back in time so the new one does not get the same name.
+	for k, v := range f.v1 {
+		if strings.HasPrefix(k, archive+"/") {
+			delete(f.v1, k)
+			f.v1["kv1/archive/kv1/users/alice-20200101T000000Z"+strings.TrimPrefix(k, archive)] = v
+		}
+	}
+	writeUser()
+	f.v1["kv1/users/alice/example"] = map[string]interface{}{"value": "changed"}
+	if err := userResourceDelete(userDestroyData(t, "kv1/users/alice", raw), meta); err != nil {
+		t.Fatal(err)
+	}
+	keys := archives()
+	if len(keys) != 2 {
+		t.Fatalf("expected a second archive, got %v", keys)
+	}
+	archive = "kv1/archive/kv1/users/" + strings.TrimSuffix(keys[1].(string), "/")
+	if f.v1[archive+"/example"]["value"] != "changed" {
+		t.Fatalf("expected the changed user to be archived to %s, got %v", archive, f.v1)
+	}
+}
+
+func TestUserResourceSecretMetadataKVv1(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+
+	_, err := applyResource(t, resourceUser(), nil, map[string]interface{}{
+		"name":      "alice",
+		"base_path": "kv1/users",
+		"secret_metadata": []interface{}{
+			map[string]interface{}{"max_versions": 5},
+		},
+	}, meta)
+	if err == nil || !strings.Contains(err.Error(), "requires a KV v2 mount") {
+		t.Fatalf("expected a KV v2 mount error, got %v", err)
+	}
+	if len(f.v1) != 0 {
+		t.Fatalf("expected nothing to be written, got %v", f.v1)
+	}
+}
+
+func TestUserResourceSecretMetadata(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+	r := resourceUser()
+
+	raw := map[string]interface{}{
+		"name":      "alice",
+		"base_path": "secret/users",
+		"secret_metadata": []interface{}{
+			map[string]interface{}{
+				"max_versions":    5,
+				"custom_metadata": map[string]interface{}{"owner": "team"},
+			},
+		},
+	}
+	state, err := applyResource(t, r, nil, raw, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings := f.settings["users/alice/example"]; settings["max_versions"] != float64(5) {
+		t.Fatalf("expected secret_metadata to be written, got %v", settings)
+	}
+
+	delete(raw, "secret_metadata")
+	if _, err := applyResource(t, r, state, raw, meta); err != nil {
+		t.Fatal(err)
+	}
+	settings := f.settings["users/alice/example"]
+	if settings["max_versions"] != float64(0) || len(settings["custom_metadata"].(map[string]interface{})) != 0 {
+		t.Fatalf("expected removing secret_metadata to reset the metadata, got %v", settings)
+	}
+}
+
+func TestUserResourceDeleteArchiveSameName(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+
+	raw := map[string]interface{}{
+		"archive_on_destroy": true,
+		"archive_path":       "kv1/archive",
+	}
+
+	// Every user holds the same example secret.
+	for _, basePath := range []string{"kv1/users", "kv1/admins"} {
+		f.v1[basePath+"/alice/example"] = map[string]interface{}{"example": "You should not delete this."}
+	}
+	f.v1["kv1/admins/alice/ssh/key"] = map[string]interface{}{"value": "admin"}
+
+	for _, basePath := range []string{"kv1/users", "kv1/admins"} {
+		if err := userResourceDelete(userDestroyData(t, basePath+"/alice", raw), meta); err != nil {
+			t.Fatal(err)
+		}
+
+		keys := f.list("kv1/archive/"+basePath, f.v1Keys())
+		if len(keys) != 1 {
+			t.Fatalf("expected one archive of %s/alice, got %v", basePath, keys)
+		}
+	}
+
+	keys := f.list("kv1/archive/kv1/admins", f.v1Keys())
+	archive := "kv1/archive/kv1/admins/" + strings.TrimSuffix(keys[0].(string), "/")
+	if f.v1[archive+"/ssh/key"]["value"] != "admin" {
+		t.Fatalf("expected kv1/admins/alice to be archived to %s, got %v", archive, f.v1)
+	}
+}
+
+func TestUserResourceDeleteArchiveDeleteVersionAfter(t *testing.T) {
+	f := newFakeKV(t)
+	meta := f.meta()
+
+	for _, p := range []string{"secret/users/alice/example", "secret/users/alice/ssh/key"} {
+		for _, v := range []string{"v1", "v2"} {
+			if err := addVersionedSecret(p, &map[string]interface{}{"k": v}, meta); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Every live version gets a deletion_time in the future.
+		f.settings[strings.TrimPrefix(p, "secret/")] = map[string]interface{}{"delete_version_after": "720h"}
+	}
+	markVersions(f.deleted, "users/alice/ssh/key", []int{1}, true)
+
+	err := userResourceDelete(userDestroyData(t, "secret/users/alice", map[string]interface{}{
+		"archive_on_destroy": true,
+		"archive_path":       "secret/archive",
+	}), meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := f.list("archive/secret/users", f.v2Keys())
+	if len(keys) != 1 {
+		t.Fatalf("expected one archive, got %v", keys)
+	}
+	archive := "archive/secret/users/" + strings.TrimSuffix(keys[0].(string), "/")
+
+	if got := f.v2[archive+"/example"]; len(got) != 2 || got[0]["k"] != "v1" || got[1]["k"] != "v2" {
+		t.Fatalf("expected both versions of example to be archived, got %v", got)
+	}
+	if got := f.v2[archive+"/ssh/key"]; len(got) != 1 || got[0]["k"] != "v2" {
+		t.Fatalf("expected the version of ssh/key not deleted to be archived, got %v", got)
+	}
+}